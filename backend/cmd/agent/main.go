@@ -0,0 +1,547 @@
+// 节点代理：运行在每台代理服务器上，向面板注册并定期上报系统状态、在线用户和用户流量，
+// 拉取面板生成的 Hysteria2 配置和 TLS 证书，有变化时写入本地并重启 hysteria 服务。
+// 独立端口用户的服务端实例配置写入配置文件所在目录的 instances 子目录，由 hysteria-instance@<端口> 运行。
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const version = "0.1.0"
+
+// 运行独立端口用户实例的 systemd 模板服务，由安装脚本写入
+const instanceService = "hysteria-instance"
+
+type Agent struct {
+	panelURL     string
+	nodeID       uint
+	token        string
+	configPath   string
+	serviceName  string
+	client       *http.Client
+	lastConfigID [32]byte
+	runningVer   int // 已上报的正在运行的配置版本
+	// 已从 hysteria 取出但尚未上报成功的用户流量，key 为用户ID
+	pendingTraffic map[string]*userTraffic
+}
+
+// 用户流量，字段对应 hysteria 流量统计接口 /traffic 的返回：tx 为客户端上传，rx 为客户端下载
+type userTraffic struct {
+	Tx int64 `json:"tx"`
+	Rx int64 `json:"rx"`
+}
+
+func main() {
+	panelURL := flag.String("panel", os.Getenv("HY2_PANEL_URL"), "面板地址，例如 https://panel.example.com")
+	nodeID := flag.Uint("node", envUint("HY2_NODE_ID"), "节点ID")
+	token := flag.String("token", os.Getenv("HY2_NODE_TOKEN"), "节点认证令牌")
+	configPath := flag.String("config", "/etc/hysteria/config.json", "hysteria 配置文件路径")
+	serviceName := flag.String("service", "hysteria-server", "hysteria 的 systemd 服务名")
+	reportInterval := flag.Duration("interval", 30*time.Second, "状态上报间隔")
+	syncInterval := flag.Duration("sync-interval", time.Minute, "配置同步间隔")
+	flag.Parse()
+
+	if *panelURL == "" || *nodeID == 0 || *token == "" {
+		log.Fatal("必须指定面板地址、节点ID和节点令牌")
+	}
+
+	agent := &Agent{
+		panelURL:    strings.TrimRight(*panelURL, "/"),
+		nodeID:      *nodeID,
+		token:       *token,
+		configPath:  *configPath,
+		serviceName: *serviceName,
+		client:      &http.Client{Timeout: 15 * time.Second},
+
+		pendingTraffic: make(map[string]*userTraffic),
+	}
+
+	if err := agent.register(); err != nil {
+		log.Fatalf("节点注册失败: %v", err)
+	}
+	log.Printf("节点 %d 注册成功", agent.nodeID)
+
+	// 记录当前配置，避免启动时无变化也重启服务，首次同步时上报对应的配置版本
+	if data, err := os.ReadFile(agent.configPath); err == nil {
+		agent.lastConfigID = sha256.Sum256(data)
+	}
+
+	agent.syncConfig()
+	agent.reportStatus()
+
+	reportTicker := time.NewTicker(*reportInterval)
+	syncTicker := time.NewTicker(*syncInterval)
+	for {
+		select {
+		case <-reportTicker.C:
+			agent.reportStatus()
+			agent.reportOnline()
+			agent.reportTraffic()
+		case <-syncTicker.C:
+			agent.syncConfig()
+		}
+	}
+}
+
+// 向面板注册节点
+func (a *Agent) register() error {
+	hostname, _ := os.Hostname()
+	body := map[string]string{
+		"version":  version,
+		"hostname": hostname,
+	}
+	_, err := a.request(http.MethodPost, "register", body)
+	return err
+}
+
+// 上报系统状态
+func (a *Agent) reportStatus() {
+	status, err := collectStatus()
+	if err != nil {
+		log.Printf("采集系统状态失败: %v", err)
+		return
+	}
+	status.LastReportAt = time.Now().Unix()
+
+	if _, err := a.request(http.MethodPost, "status", status); err != nil {
+		log.Printf("上报节点状态失败: %v", err)
+	}
+}
+
+// 从各实例的 hysteria 流量统计接口获取在线用户并上报，踢出面板返回的超出设备数限制或凭证已变更的用户
+func (a *Agent) reportOnline() {
+	endpoints := a.statsEndpoints()
+	if len(endpoints) == 0 {
+		return
+	}
+
+	online := make(map[string]int)
+	fetched := false
+	for _, stats := range endpoints {
+		var counts map[string]int
+		data, err := a.statsRequest(stats, http.MethodGet, "online", nil)
+		if err != nil {
+			log.Printf("获取 %s 在线用户失败: %v", stats.Listen, err)
+			continue
+		}
+		if err := json.Unmarshal(data, &counts); err != nil {
+			log.Printf("解析 %s 在线用户失败: %v", stats.Listen, err)
+			continue
+		}
+		fetched = true
+
+		// userpass 认证的用户名为用户ID
+		for user, count := range counts {
+			if _, err := strconv.ParseUint(user, 10, 32); err == nil {
+				online[user] += count
+			}
+		}
+	}
+
+	// 所有实例都获取失败时不上报，避免面板将用户全部视为离线
+	if !fetched {
+		return
+	}
+
+	data, err := a.request(http.MethodPost, "online", online)
+	if err != nil {
+		log.Printf("上报在线用户失败: %v", err)
+		return
+	}
+	var resp struct {
+		Kick []uint `json:"kick"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		log.Printf("解析踢出列表失败: %v", err)
+		return
+	}
+	if len(resp.Kick) == 0 {
+		return
+	}
+
+	users := make([]string, 0, len(resp.Kick))
+	for _, userID := range resp.Kick {
+		users = append(users, strconv.FormatUint(uint64(userID), 10))
+	}
+	// 用户可能连接在共用端口或自己的实例上，向所有实例发送踢出请求
+	for _, stats := range endpoints {
+		if _, err := a.statsRequest(stats, http.MethodPost, "kick", users); err != nil {
+			log.Printf("在 %s 踢出用户失败: %v", stats.Listen, err)
+		}
+	}
+	log.Printf("已踢出用户: %s", strings.Join(users, ","))
+}
+
+// 从各实例的 hysteria 流量统计接口取出并清零各用户流量，逐个用户上报到面板。
+// 上报失败的流量保留到下次一并上报，避免清零后丢失
+func (a *Agent) reportTraffic() {
+	for _, stats := range a.statsEndpoints() {
+		data, err := a.statsRequest(stats, http.MethodGet, "traffic?clear=1", nil)
+		if err != nil {
+			log.Printf("获取 %s 用户流量失败: %v", stats.Listen, err)
+			continue
+		}
+		var traffic map[string]userTraffic
+		if err := json.Unmarshal(data, &traffic); err != nil {
+			log.Printf("解析 %s 用户流量失败: %v", stats.Listen, err)
+			continue
+		}
+
+		for user, t := range traffic {
+			// userpass 认证的用户名为用户ID
+			if _, err := strconv.ParseUint(user, 10, 32); err != nil {
+				continue
+			}
+			pending, ok := a.pendingTraffic[user]
+			if !ok {
+				pending = &userTraffic{}
+				a.pendingTraffic[user] = pending
+			}
+			pending.Tx += t.Tx
+			pending.Rx += t.Rx
+		}
+	}
+
+	for user, t := range a.pendingTraffic {
+		if t.Tx == 0 && t.Rx == 0 {
+			delete(a.pendingTraffic, user)
+			continue
+		}
+		userID, _ := strconv.ParseUint(user, 10, 32)
+		body := map[string]interface{}{
+			"user_id":  userID,
+			"upload":   t.Tx,
+			"download": t.Rx,
+		}
+		if _, err := a.request(http.MethodPost, "traffic", body); err != nil {
+			log.Printf("上报用户 %s 流量失败: %v", user, err)
+			continue
+		}
+		delete(a.pendingTraffic, user)
+	}
+}
+
+// 面板下发的配置中的流量统计接口
+type trafficStatsConfig struct {
+	Listen string `json:"listen"`
+	Secret string `json:"secret"`
+}
+
+// 共用端口实例和各用户实例的流量统计接口，读取失败的实例记录日志后跳过
+func (a *Agent) statsEndpoints() []*trafficStatsConfig {
+	paths := []string{a.configPath}
+	instances, err := filepath.Glob(filepath.Join(a.instanceDir(), "*.json"))
+	if err != nil {
+		log.Printf("读取实例配置失败: %v", err)
+	}
+	paths = append(paths, instances...)
+
+	var endpoints []*trafficStatsConfig
+	for _, path := range paths {
+		stats, err := trafficStats(path)
+		if err != nil {
+			log.Printf("读取 %s 的流量统计接口配置失败: %v", path, err)
+			continue
+		}
+		endpoints = append(endpoints, stats)
+	}
+	return endpoints
+}
+
+// 从配置文件读取流量统计接口地址和密钥
+func trafficStats(path string) (*trafficStatsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		TrafficStats *trafficStatsConfig `json:"trafficStats"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if config.TrafficStats == nil || config.TrafficStats.Listen == "" {
+		return nil, fmt.Errorf("配置中未启用流量统计接口")
+	}
+	return config.TrafficStats, nil
+}
+
+// 请求 hysteria 流量统计接口
+func (a *Agent) statsRequest(stats *trafficStatsConfig, method, action string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	host := stats.Listen
+	if strings.HasPrefix(host, ":") {
+		host = "127.0.0.1" + host
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s/%s", host, action), reader)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Secret != "" {
+		req.Header.Set("Authorization", stats.Secret)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(data))
+	}
+	return data, nil
+}
+
+// 拉取配置和证书，有变化时写入并重启 hysteria
+func (a *Agent) syncConfig() {
+	data, header, err := a.requestHeader(http.MethodGet, "config", nil)
+	if err != nil {
+		log.Printf("拉取节点配置失败: %v", err)
+		return
+	}
+	version, _ := strconv.Atoi(header.Get("X-Config-Version"))
+
+	// 证书写入配置中的证书路径，证书更新后同样需要重启
+	certChanged, err := a.syncCert(data)
+	if err != nil {
+		log.Printf("同步 TLS 证书失败: %v", err)
+		return
+	}
+	a.syncInstances(certChanged)
+
+	// 用户认证信息不属于配置版本，配置内容变化而版本不变时同样需要重启；
+	// 内容不变而版本变化（如固定到内容相同的版本）时只上报版本
+	id := sha256.Sum256(data)
+	if id == a.lastConfigID && !certChanged {
+		if version != a.runningVer {
+			a.reportRunning(version)
+		}
+		return
+	}
+
+	if err := writeFileAtomic(a.configPath, data); err != nil {
+		log.Printf("写入配置文件失败: %v", err)
+		return
+	}
+
+	// 重启成功后才记录配置，失败时下次同步会重试
+	output, err := exec.Command("systemctl", "restart", a.serviceName).CombinedOutput()
+	if err != nil {
+		log.Printf("重启 %s 失败: %v, 输出: %s", a.serviceName, err, string(output))
+		return
+	}
+	a.lastConfigID = id
+	log.Printf("配置已更新，%s 已重启", a.serviceName)
+	a.reportRunning(version)
+}
+
+// 用户实例配置所在目录
+func (a *Agent) instanceDir() string {
+	return filepath.Join(filepath.Dir(a.configPath), "instances")
+}
+
+// 拉取独立端口用户的实例配置，写入有变化的实例并重启，证书变化时重启全部实例；
+// 停止并删除面板不再下发的实例
+func (a *Agent) syncInstances(certChanged bool) {
+	data, err := a.request(http.MethodGet, "instances", nil)
+	if err != nil {
+		log.Printf("拉取实例配置失败: %v", err)
+		return
+	}
+	var resp struct {
+		Instances map[string]json.RawMessage `json:"instances"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		log.Printf("解析实例配置失败: %v", err)
+		return
+	}
+
+	dir := a.instanceDir()
+	for port, config := range resp.Instances {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			continue
+		}
+		path := filepath.Join(dir, port+".json")
+		current, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(current, config) && !certChanged {
+			continue
+		}
+		if err := writeFileAtomic(path, config); err != nil {
+			log.Printf("写入实例 %s 配置失败: %v", port, err)
+			continue
+		}
+		unit := instanceService + "@" + port
+		if output, err := exec.Command("systemctl", "enable", unit).CombinedOutput(); err != nil {
+			log.Printf("启用 %s 失败: %v, 输出: %s", unit, err, string(output))
+			continue
+		}
+		if output, err := exec.Command("systemctl", "restart", unit).CombinedOutput(); err != nil {
+			log.Printf("重启 %s 失败: %v, 输出: %s", unit, err, string(output))
+			continue
+		}
+		log.Printf("实例配置已更新，%s 已重启", unit)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Printf("读取实例配置失败: %v", err)
+		return
+	}
+	for _, path := range files {
+		port := strings.TrimSuffix(filepath.Base(path), ".json")
+		if _, ok := resp.Instances[port]; ok {
+			continue
+		}
+		unit := instanceService + "@" + port
+		if output, err := exec.Command("systemctl", "disable", "--now", unit).CombinedOutput(); err != nil {
+			log.Printf("停止 %s 失败: %v, 输出: %s", unit, err, string(output))
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("删除实例 %s 配置失败: %v", port, err)
+			continue
+		}
+		log.Printf("已停止实例 %s", unit)
+	}
+}
+
+// 拉取面板的 TLS 证书，写入配置中 tls.cert 和 tls.key 指定的路径，返回证书是否有变化
+func (a *Agent) syncCert(configData []byte) (bool, error) {
+	var config struct {
+		TLS *struct {
+			Cert string `json:"cert"`
+			Key  string `json:"key"`
+		} `json:"tls"`
+	}
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return false, err
+	}
+	if config.TLS == nil || config.TLS.Cert == "" || config.TLS.Key == "" {
+		return false, nil
+	}
+
+	data, err := a.request(http.MethodGet, "cert", nil)
+	if err != nil {
+		return false, err
+	}
+	var cert struct {
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+	}
+	if err := json.Unmarshal(data, &cert); err != nil {
+		return false, err
+	}
+
+	changed := false
+	for path, content := range map[string]string{config.TLS.Cert: cert.Cert, config.TLS.Key: cert.Key} {
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, []byte(content)) {
+			continue
+		}
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// 上报正在运行的配置版本，面板据此记录节点运行的配置版本
+func (a *Agent) reportRunning(version int) {
+	body := map[string]int{"version": version}
+	if _, err := a.request(http.MethodPost, "config/running", body); err != nil {
+		log.Printf("上报运行配置失败: %v", err)
+		return
+	}
+	a.runningVer = version
+}
+
+// 请求面板的节点接口
+func (a *Agent) request(method, action string, body interface{}) ([]byte, error) {
+	data, _, err := a.requestHeader(method, action, body)
+	return data, err
+}
+
+// 请求面板的节点接口，同时返回响应头
+func (a *Agent) requestHeader(method, action string, body interface{}) ([]byte, http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	url := fmt.Sprintf("%s/api/nodes/%d/%s", a.panelURL, a.nodeID, action)
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(data))
+	}
+
+	return data, resp.Header, nil
+}
+
+// 先写临时文件再重命名，避免 hysteria 读到不完整的配置
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func envUint(key string) uint {
+	v, _ := strconv.ParseUint(os.Getenv(key), 10, 32)
+	return uint(v)
+}
@@ -0,0 +1,183 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"hysteria2-panel/models"
+)
+
+// 从 /proc 采集系统状态
+func collectStatus() (*models.NodeStatus, error) {
+	cpu, err := cpuUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	memory, err := memoryUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	disk, err := diskUsage("/")
+	if err != nil {
+		return nil, err
+	}
+
+	load, err := loadAverage()
+	if err != nil {
+		return nil, err
+	}
+
+	networkIn, networkOut, err := networkTraffic()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.NodeStatus{
+		CPU:        cpu,
+		Memory:     memory,
+		Disk:       disk,
+		Load:       load,
+		NetworkIn:  networkIn,
+		NetworkOut: networkOut,
+	}, nil
+}
+
+// 间隔一秒读取两次 /proc/stat 计算CPU使用率
+func cpuUsage() (float64, error) {
+	idle1, total1, err := readCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(time.Second)
+	idle2, total2, err := readCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+
+	if total2 <= total1 {
+		return 0, nil
+	}
+	return (1 - float64(idle2-idle1)/float64(total2-total1)) * 100, nil
+}
+
+func readCPUTimes() (idle, total uint64, err error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	line := strings.SplitN(string(data), "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("无法解析 /proc/stat")
+	}
+
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += v
+		// idle 和 iowait
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return idle, total, nil
+}
+
+// 内存使用率
+func memoryUsage() (float64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = v
+	}
+
+	total := values["MemTotal"]
+	if total == 0 {
+		return 0, fmt.Errorf("无法解析 /proc/meminfo")
+	}
+	return float64(total-values["MemAvailable"]) / float64(total) * 100, nil
+}
+
+// 磁盘使用率
+func diskUsage(path string) (float64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	total := stat.Blocks * uint64(stat.Bsize)
+	if total == 0 {
+		return 0, nil
+	}
+	free := stat.Bavail * uint64(stat.Bsize)
+	return float64(total-free) / float64(total) * 100, nil
+}
+
+// 1分钟平均负载
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("无法解析 /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// 除回环网卡外所有网卡的累计收发字节数
+func networkTraffic() (int64, int64, error) {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	var rx, tx int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "lo" {
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) < 9 {
+			continue
+		}
+		in, _ := strconv.ParseInt(fields[0], 10, 64)
+		out, _ := strconv.ParseInt(fields[8], 10, 64)
+		rx += in
+		tx += out
+	}
+
+	return rx, tx, scanner.Err()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	"hysteria2-panel/models"
+)
+
+// 目前只支持在 Linux 上采集系统状态
+func collectStatus() (*models.NodeStatus, error) {
+	return nil, errors.New("当前系统不支持采集节点状态")
+}
@@ -12,6 +12,10 @@ type Config struct {
 	Domain      string `json:"domain"`
 	Email       string `json:"email"`
 
+	// 可信的反向代理地址（IP或CIDR），只有来自这些地址的请求才会采用 X-Forwarded-For 中的客户端IP，
+	// 为空表示不信任任何代理，直接使用连接的对端地址
+	TrustedProxies []string `json:"trusted_proxies"`
+
 	// 数据库配置
 	Database struct {
 		Type     string `json:"type"`
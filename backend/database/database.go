@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"hysteria2-panel/config"
 	"hysteria2-panel/models"
+	"strings"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -23,18 +24,137 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	// 金额字段由小数改为最小货币单位整数，需在结构迁移前换算旧数据并修改列类型
+	if err := migrateMoneyColumns(db); err != nil {
+		return nil, err
+	}
+
+	// 新增的快速打开选项对已有节点默认启用，保持升级前的客户端配置
+	if err := migrateNodeFastOpen(db); err != nil {
+		return nil, err
+	}
+
 	// 自动迁移数据库结构
 	if err := db.AutoMigrate(
 		&models.User{},
 		&models.UserConfig{},
+		&models.SubscriptionToken{},
+		&models.SubscriptionFetch{},
 		&models.Node{},
+		&models.NodeGroup{},
+		&models.NodeCredential{},
+		&models.NodeStatusRecord{},
+		&models.NodeIncident{},
+		&models.NodeProbe{},
+		&models.NodeRouting{},
+		&models.NodeSSH{},
+		&models.Deployment{},
+		&models.NodeConfigVersion{},
 		&models.Setting{},
 		&models.Plan{},
+		&models.PlanPrice{},
 		&models.Subscription{},
 		&models.Order{},
+		&models.Invoice{},
+		&models.ExchangeRate{},
 	); err != nil {
 		return nil, err
 	}
 
 	return db, nil
 }
+
+// 将旧版本以浮点数存储的金额（元）换算为整数的最小货币单位（分），并立即修改列类型。
+// 换算和换算标记在同一事务中写入，修改列类型失败后再次启动不会重复换算
+func migrateMoneyColumns(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Setting{}); err != nil {
+		return err
+	}
+
+	columns := []struct {
+		model  interface{}
+		table  string
+		column string
+	}{
+		{&models.Plan{}, "plans", "price"},
+		{&models.Order{}, "orders", "amount"},
+		{&models.Invoice{}, "invoices", "subtotal"},
+		{&models.Invoice{}, "invoices", "tax_amount"},
+		{&models.Invoice{}, "invoices", "total"},
+	}
+
+	for _, c := range columns {
+		if !db.Migrator().HasTable(c.model) {
+			continue
+		}
+
+		columnTypes, err := db.Migrator().ColumnTypes(c.model)
+		if err != nil {
+			return err
+		}
+
+		for _, columnType := range columnTypes {
+			if columnType.Name() != c.column {
+				continue
+			}
+			switch strings.ToLower(columnType.DatabaseTypeName()) {
+			case "double", "float", "decimal":
+			default:
+				continue
+			}
+
+			marker := fmt.Sprintf("money_minor_units:%s.%s", c.table, c.column)
+			var count int64
+			if err := db.Model(&models.Setting{}).Where("`key` = ?", marker).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				err := db.Transaction(func(tx *gorm.DB) error {
+					sql := fmt.Sprintf("UPDATE `%s` SET `%s` = ROUND(`%s` * 100)", c.table, c.column, c.column)
+					if err := tx.Exec(sql).Error; err != nil {
+						return err
+					}
+					return tx.Create(&models.Setting{Key: marker, Value: "true"}).Error
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := db.Migrator().AlterColumn(c.model, c.column); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 为已有节点添加快速打开选项并设为启用，新安装只写入迁移标记。
+// 迁移标记和数据更新在同一事务中写入，之后管理员关闭的选项不会被再次启用
+func migrateNodeFastOpen(db *gorm.DB) error {
+	const marker = "migration:nodes.fast_open"
+
+	var count int64
+	if err := db.Model(&models.Setting{}).Where("`key` = ?", marker).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if !db.Migrator().HasTable(&models.Node{}) {
+		return db.Create(&models.Setting{Key: marker, Value: "true"}).Error
+	}
+	if !db.Migrator().HasColumn(&models.Node{}, "FastOpen") {
+		if err := db.Migrator().AddColumn(&models.Node{}, "FastOpen"); err != nil {
+			return err
+		}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE `nodes` SET `fast_open` = ?", true).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Setting{Key: marker, Value: "true"}).Error
+	})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConfigVersionHandler struct {
+	configVersionService *services.ConfigVersionService
+}
+
+func NewConfigVersionHandler(configVersionService *services.ConfigVersionService) *ConfigVersionHandler {
+	return &ConfigVersionHandler{configVersionService: configVersionService}
+}
+
+// 获取节点服务端配置，供节点代理拉取。响应头中带有配置版本和节点级配置的内容哈希
+func (h *ConfigVersionHandler) GetNodeConfig(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	version, data, err := h.configVersionService.GetNodeConfig(uint(nodeID), format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "application/json; charset=utf-8"
+	if format == "yaml" || format == "yml" {
+		contentType = "application/yaml; charset=utf-8"
+	}
+	c.Header("X-Config-Version", strconv.Itoa(version.Version))
+	c.Header("X-Config-Hash", version.Hash)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// 获取节点上独立端口用户的服务端实例配置，供节点代理拉取，key 为端口
+func (h *ConfigVersionHandler) GetNodeInstances(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	instances, err := h.configVersionService.RenderNodeInstances(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"instances": instances})
+}
+
+// 节点代理上报正在运行的配置版本，即拉取配置时响应头 X-Config-Version 的值
+func (h *ConfigVersionHandler) ReportRunning(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		Version int `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Version < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	version, err := h.configVersionService.ReportRunning(uint(nodeID), req.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"version": version})
+}
+
+// 获取节点的配置版本列表
+func (h *ConfigVersionHandler) GetVersions(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	versions, err := h.configVersionService.GetVersions(uint(nodeID), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// 获取节点的指定配置版本
+func (h *ConfigVersionHandler) GetVersion(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return
+	}
+
+	v, err := h.configVersionService.GetVersion(uint(nodeID), version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"version": v})
+}
+
+// 比较节点的两个配置版本
+func (h *ConfigVersionHandler) DiffVersions(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return
+	}
+	to, err := strconv.Atoi(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return
+	}
+
+	diff, err := h.configVersionService.DiffVersions(uint(nodeID), from, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"diff": diff})
+}
+
+// 固定节点下发的配置版本，version 为0时恢复下发最新配置
+func (h *ConfigVersionHandler) PinVersion(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		Version int `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Version < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.configVersionService.PinVersion(uint(nodeID), req.Version); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "配置版本设置成功"})
+}
+
+// 将节点回滚到上一个配置版本
+func (h *ConfigVersionHandler) Rollback(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	version, err := h.configVersionService.Rollback(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已回滚并固定到历史版本", "version": version})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
 	"hysteria2-panel/models"
 	"hysteria2-panel/services"
@@ -18,9 +19,13 @@ func NewConfigHandler(configManager *services.ConfigManagerService) *ConfigHandl
 }
 
 func (h *ConfigHandler) GetUserConfig(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
 
-	config, err := h.configManager.GetUserConfig(id)
+	config, err := h.configManager.GetUserConfig(uint(id))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -30,14 +35,19 @@ func (h *ConfigHandler) GetUserConfig(c *gin.Context) {
 }
 
 func (h *ConfigHandler) UpdateUserConfig(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+
 	var config models.UserConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
 		return
 	}
 
-	if err := h.configManager.UpdateUserConfig(id, &config); err != nil {
+	if err := h.configManager.UpdateUserConfig(uint(id), &config); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CurrencyHandler struct {
+	currencyService *services.CurrencyService
+}
+
+func NewCurrencyHandler(currencyService *services.CurrencyService) *CurrencyHandler {
+	return &CurrencyHandler{currencyService: currencyService}
+}
+
+// 获取汇率列表
+func (h *CurrencyHandler) GetRates(c *gin.Context) {
+	rates, err := h.currencyService.GetRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rates": rates})
+}
+
+// 手动设置汇率
+func (h *CurrencyHandler) SetRate(c *gin.Context) {
+	var req struct {
+		From string  `json:"from" binding:"required"`
+		To   string  `json:"to" binding:"required"`
+		Rate float64 `json:"rate" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.currencyService.SetRate(req.From, req.To, req.Rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "汇率设置成功"})
+}
+
+// 删除汇率
+func (h *CurrencyHandler) DeleteRate(c *gin.Context) {
+	if err := h.currencyService.DeleteRate(c.Query("from"), c.Query("to")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "汇率删除成功"})
+}
+
+// 立即从汇率接口获取汇率
+func (h *CurrencyHandler) FetchRates(c *gin.Context) {
+	if err := h.currencyService.FetchRates(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "汇率获取成功"})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"hysteria2-panel/models"
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeployHandler struct {
+	deployService *services.DeployService
+}
+
+func NewDeployHandler(deployService *services.DeployService) *DeployHandler {
+	return &DeployHandler{deployService: deployService}
+}
+
+// 获取节点 SSH 连接信息，不返回私钥
+func (h *DeployHandler) GetNodeSSH(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	sshConfig, err := h.deployService.GetNodeSSH(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ssh":             sshConfig,
+		"has_private_key": sshConfig.PrivateKey != "",
+	})
+}
+
+// 设置节点 SSH 连接信息
+func (h *DeployHandler) SetNodeSSH(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		Port         int    `json:"port" binding:"required"`
+		User         string `json:"user" binding:"required"`
+		PrivateKey   string `json:"private_key"` // 为空时保留原私钥
+		ConfigPath   string `json:"config_path" binding:"required"`
+		CertPath     string `json:"cert_path" binding:"required"`
+		KeyPath      string `json:"key_path" binding:"required"`
+		Service      string `json:"service" binding:"required"`
+		ResetHostKey bool   `json:"reset_host_key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	sshConfig := &models.NodeSSH{
+		Port:       req.Port,
+		User:       req.User,
+		PrivateKey: req.PrivateKey,
+		ConfigPath: req.ConfigPath,
+		CertPath:   req.CertPath,
+		KeyPath:    req.KeyPath,
+		Service:    req.Service,
+	}
+	if err := h.deployService.SetNodeSSH(uint(nodeID), sshConfig, req.ResetHostKey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SSH 连接信息更新成功"})
+}
+
+// 向节点推送配置并重启服务，部署在后台执行
+func (h *DeployHandler) Deploy(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	deployment, err := h.deployService.Deploy(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"deployment": deployment})
+}
+
+// 获取节点的部署记录
+func (h *DeployHandler) GetDeployments(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	deployments, err := h.deployService.GetDeployments(uint(nodeID), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deployments": deployments})
+}
+
+// 获取部署记录详情和日志
+func (h *DeployHandler) GetDeployment(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的部署ID"})
+		return
+	}
+
+	deployment, err := h.deployService.GetDeployment(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deployment": deployment})
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 
+	"hysteria2-panel/models"
 	"hysteria2-panel/services"
 
 	"github.com/gin-gonic/gin"
@@ -11,19 +15,25 @@ import (
 type Hysteria2Handler struct {
 	configManager *services.ConfigManagerService
 	hy2Service    *services.Hysteria2Service
+	nodeService   *services.NodeService
 }
 
-func NewHysteria2Handler(configManager *services.ConfigManagerService, hy2Service *services.Hysteria2Service) *Hysteria2Handler {
+func NewHysteria2Handler(configManager *services.ConfigManagerService, hy2Service *services.Hysteria2Service, nodeService *services.NodeService) *Hysteria2Handler {
 	return &Hysteria2Handler{
 		configManager: configManager,
 		hy2Service:    hy2Service,
+		nodeService:   nodeService,
 	}
 }
 
 func (h *Hysteria2Handler) GenerateServerConfig(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
 
-	config, err := h.configManager.GetUserConfig(id)
+	config, err := h.configManager.GetUserConfig(uint(id))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -37,25 +47,161 @@ func (h *Hysteria2Handler) GenerateServerConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "服务器配置生成成功"})
 }
 
+// 生成用户在指定节点上的客户端配置，只能使用当前套餐授权的节点
 func (h *Hysteria2Handler) GetClientConfig(c *gin.Context) {
-	id := c.Param("id")
-	domain := c.Query("domain")
-	if domain == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "domain参数不能为空"})
+	_, clientConfig, ok := h.userClientConfig(c)
+	if !ok {
+		return
+	}
+
+	// 指定 format=yaml 时直接返回可供客户端使用的配置文件
+	format := c.Query("format")
+	if format == "" {
+		c.JSON(http.StatusOK, gin.H{"config": clientConfig})
+		return
+	}
+
+	writeConfig(c, clientConfig, format)
+}
+
+// 获取用户在指定节点上的 hysteria2:// 分享链接
+func (h *Hysteria2Handler) GetShareURI(c *gin.Context) {
+	node, clientConfig, ok := h.userClientConfig(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"uri": services.ShareURI(node.Name, clientConfig)})
+}
+
+// 获取分享链接二维码，format 可选 png、svg，size 为图片边长（像素）
+func (h *Hysteria2Handler) GetShareQRCode(c *gin.Context) {
+	node, clientConfig, ok := h.userClientConfig(c)
+	if !ok {
+		return
+	}
+
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if size < 64 || size > 1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "二维码尺寸应在64到1024之间"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "png")
+	image, err := services.ShareQRCode(services.ShareURI(node.Name, clientConfig), format, size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "image/png"
+	if format == "svg" {
+		contentType = "image/svg+xml"
+	}
+	c.Data(http.StatusOK, contentType, image)
+}
+
+// 解析分享链接，用于导入
+func (h *Hysteria2Handler) ParseShareURI(c *gin.Context) {
+	var req struct {
+		URI string `json:"uri" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	config, name, err := services.ParseShareURI(req.URI)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"name": name, "config": config})
+}
+
+// 根据路径中的用户ID和 node_id 参数生成客户端配置，普通用户只能获取自己的配置，失败时已写入错误响应
+func (h *Hysteria2Handler) userClientConfig(c *gin.Context) (*models.Node, *services.ClientConfig, bool) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return nil, nil, false
+	}
+	nodeID, err := strconv.ParseUint(c.Query("node_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return nil, nil, false
+	}
+
+	node, err := h.nodeService.GetUserNode(userID, uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	config, err := h.configManager.GetUserConfig(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	clientConfig, err := h.hy2Service.GenerateClientConfig(node, config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	return node, clientConfig, true
+}
+
+// 获取节点端口跳跃的 iptables 规则，需要在节点上执行
+func (h *Hysteria2Handler) GetPortHoppingRules(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	node, err := h.nodeService.GetNode(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := h.hy2Service.GeneratePortHoppingRules(node)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	config, err := h.configManager.GetUserConfig(id)
+	c.JSON(http.StatusOK, gin.H{
+		"listen": fmt.Sprintf(":%d", node.Port),
+		"server": net.JoinHostPort(node.Host, node.HopPorts),
+		"rules":  rules,
+	})
+}
+
+// 按指定格式输出配置文件
+func writeConfig(c *gin.Context, config interface{}, format string) {
+	data, err := services.EncodeConfig(config, format)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	clientConfig, err := h.hy2Service.GenerateClientConfig(domain, config)
+	contentType := "application/json; charset=utf-8"
+	if format == "yaml" || format == "yml" {
+		contentType = "application/yaml; charset=utf-8"
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// 获取节点使用的 TLS 证书和私钥，供节点代理写入节点配置中的证书路径
+func (h *Hysteria2Handler) GetNodeCertificate(c *gin.Context) {
+	cert, key, err := h.hy2Service.NodeCertificate()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"config": clientConfig})
+	c.JSON(http.StatusOK, gin.H{"cert": string(cert), "key": string(key)})
 }
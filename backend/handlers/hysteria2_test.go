@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 普通用户不能获取其他用户的客户端配置、分享链接和二维码
+func TestUserClientConfigForbidsOtherUsers(t *testing.T) {
+	h := NewHysteria2Handler(nil, nil, nil)
+	handlers := map[string]gin.HandlerFunc{
+		"client": h.GetClientConfig,
+		"share":  h.GetShareURI,
+		"qrcode": h.GetShareQRCode,
+	}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			c, w := newUserContext(http.MethodGet, "/api/hy2/"+name+"/9?node_id=1", 7, false)
+			c.Params = gin.Params{{Key: "id", Value: "9"}}
+
+			handler(c)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InstallerHandler struct {
+	installerService *services.InstallerService
+}
+
+func NewInstallerHandler(installerService *services.InstallerService) *InstallerHandler {
+	return &InstallerHandler{installerService: installerService}
+}
+
+// 生成节点一键安装命令和脚本，会轮换节点令牌
+func (h *InstallerHandler) GenerateNodeInstall(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		PanelURL string `json:"panel_url"` // 节点访问面板使用的地址，为空时使用当前请求的地址
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+			return
+		}
+	}
+	if req.PanelURL == "" {
+		req.PanelURL = requestBaseURL(c)
+	}
+
+	install, err := h.installerService.GenerateNodeInstall(uint(nodeID), req.PanelURL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"install": install})
+}
+
+// 通用节点安装脚本，面板地址、节点ID和令牌通过环境变量传入
+func (h *InstallerHandler) GetInstallScript(c *gin.Context) {
+	script, err := h.installerService.RenderInstallScript(nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/x-shellscript; charset=utf-8", []byte(script))
+}
+
+// 下载节点代理二进制文件
+func (h *InstallerHandler) GetAgentBinary(c *gin.Context) {
+	path, err := h.installerService.AgentBinary(c.Param("arch"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.FileAttachment(path, "hy2-agent")
+}
+
+// 根据当前请求推断面板的外部访问地址，支持反向代理设置的 X-Forwarded-Proto
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"hysteria2-panel/models"
 	"hysteria2-panel/services"
@@ -11,27 +12,76 @@ import (
 )
 
 type NodeHandler struct {
-	nodeService *services.NodeService
+	nodeService   *services.NodeService
+	onlineService *services.OnlineService
+	probeService  *services.ProbeService
 }
 
-func NewNodeHandler(nodeService *services.NodeService) *NodeHandler {
-	return &NodeHandler{nodeService: nodeService}
+func NewNodeHandler(nodeService *services.NodeService, onlineService *services.OnlineService, probeService *services.ProbeService) *NodeHandler {
+	return &NodeHandler{
+		nodeService:   nodeService,
+		onlineService: onlineService,
+		probeService:  probeService,
+	}
+}
+
+// 创建节点的请求参数，只包含可由管理员设置的字段，与 UpdateNode 允许修改的字段一致；
+// 状态、流量、混淆密码和统计密钥等由面板和节点代理维护
+type createNodeRequest struct {
+	Name                  string                `json:"name" binding:"required"`
+	Host                  string                `json:"host" binding:"required"`
+	Port                  int                   `json:"port" binding:"required"`
+	Type                  string                `json:"type"`
+	GroupID               uint                  `json:"group_id"`
+	HopPorts              string                `json:"hop_ports"`
+	HopInterval           int                   `json:"hop_interval"`
+	Masquerade            models.NodeMasquerade `json:"masquerade"`
+	BandwidthUp           string                `json:"bandwidth_up"`
+	BandwidthDown         string                `json:"bandwidth_down"`
+	IgnoreClientBandwidth bool                  `json:"ignore_client_bandwidth"`
+	FastOpen              bool                  `json:"fast_open"`
+	QUIC                  models.NodeQUIC       `json:"quic"`
+}
+
+func (r *createNodeRequest) node() *models.Node {
+	return &models.Node{
+		Name:                  r.Name,
+		Host:                  r.Host,
+		Port:                  r.Port,
+		Type:                  r.Type,
+		GroupID:               r.GroupID,
+		HopPorts:              r.HopPorts,
+		HopInterval:           r.HopInterval,
+		Masquerade:            r.Masquerade,
+		BandwidthUp:           r.BandwidthUp,
+		BandwidthDown:         r.BandwidthDown,
+		IgnoreClientBandwidth: r.IgnoreClientBandwidth,
+		FastOpen:              r.FastOpen,
+		QUIC:                  r.QUIC,
+	}
 }
 
 // 创建节点
 func (h *NodeHandler) CreateNode(c *gin.Context) {
-	var node models.Node
-	if err := c.ShouldBindJSON(&node); err != nil {
+	var req createNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
 		return
 	}
 
-	if err := h.nodeService.CreateNode(&node); err != nil {
+	node := req.node()
+	if err := services.ValidateNode(node); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.nodeService.CreateNode(node)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "节点创建成功", "node": node})
+	c.JSON(http.StatusCreated, gin.H{"message": "节点创建成功", "node": node, "token": token})
 }
 
 // 获取节点列表
@@ -45,6 +95,228 @@ func (h *NodeHandler) GetNodes(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"nodes": nodes})
 }
 
+// 更新节点
+func (h *NodeHandler) UpdateNode(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var updates map[string]interface{}
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.nodeService.UpdateNode(uint(nodeID), updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点更新成功"})
+}
+
+// 删除节点
+func (h *NodeHandler) DeleteNode(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	if err := h.nodeService.DeleteNode(uint(nodeID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点删除成功"})
+}
+
+// 设置节点维护模式
+func (h *NodeHandler) SetNodeMaintenance(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		Maintenance bool `json:"maintenance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.nodeService.SetNodeMaintenance(uint(nodeID), req.Maintenance); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点维护状态更新成功"})
+}
+
+// 启用、轮换或关闭节点混淆
+func (h *NodeHandler) SetNodeObfs(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	password, err := h.nodeService.SetNodeObfs(uint(nodeID), req.Enabled)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点混淆设置成功", "password": password})
+}
+
+// 批量操作节点
+func (h *NodeHandler) BatchNodes(c *gin.Context) {
+	var req struct {
+		NodeIDs []uint `json:"node_ids"`
+		GroupID uint   `json:"group_id"`
+		Action  string `json:"action" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	results, err := h.nodeService.BatchNodes(req.NodeIDs, req.GroupID, req.Action)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// 创建节点分组
+func (h *NodeHandler) CreateNodeGroup(c *gin.Context) {
+	var group models.NodeGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.nodeService.CreateNodeGroup(&group); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "节点分组创建成功", "group": group})
+}
+
+// 获取节点分组列表
+func (h *NodeHandler) GetNodeGroups(c *gin.Context) {
+	groups, err := h.nodeService.GetNodeGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// 更新节点分组
+func (h *NodeHandler) UpdateNodeGroup(c *gin.Context) {
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分组ID"})
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	updates := map[string]interface{}{
+		"name":        req.Name,
+		"description": req.Description,
+	}
+	if err := h.nodeService.UpdateNodeGroup(uint(groupID), updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点分组更新成功"})
+}
+
+// 删除节点分组
+func (h *NodeHandler) DeleteNodeGroup(c *gin.Context) {
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分组ID"})
+		return
+	}
+
+	if err := h.nodeService.DeleteNodeGroup(uint(groupID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点分组删除成功"})
+}
+
+// 获取用户套餐授权的可用节点
+func (h *NodeHandler) GetUserNodes(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+
+	nodes, err := h.nodeService.GetUserNodes(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"nodes": nodes})
+}
+
+// 节点代理注册
+func (h *NodeHandler) RegisterNode(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		Version  string `json:"version"`
+		Hostname string `json:"hostname"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	node, err := h.nodeService.RegisterNode(uint(nodeID), req.Version, req.Hostname)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点注册成功", "node": node})
+}
+
 // 更新节点状态
 func (h *NodeHandler) UpdateNodeStatus(c *gin.Context) {
 	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -81,5 +353,253 @@ func (h *NodeHandler) GetNodeStatus(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": status})
+	// 可选的历史范围，例如 1h、24h、168h
+	historyRange := c.Query("range")
+	if historyRange == "" {
+		c.JSON(http.StatusOK, gin.H{"status": status})
+		return
+	}
+
+	duration, err := time.ParseDuration(historyRange)
+	if err != nil || duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的时间范围"})
+		return
+	}
+
+	end := time.Now()
+	history, err := h.nodeService.GetNodeStatusHistory(uint(nodeID), end.Add(-duration), end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status, "history": history})
+}
+
+// 获取节点用户列表（含限速和设备数限制）
+func (h *NodeHandler) GetNodeUsers(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	users, err := h.nodeService.GetNodeUsers(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// 上报节点在线用户，返回需要踢下线的用户
+func (h *NodeHandler) ReportOnline(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	// 用户ID -> 在线客户端数
+	var online map[uint]int
+	if err := c.ShouldBindJSON(&online); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	kicks, err := h.onlineService.ReportOnline(uint(nodeID), online)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"kick": kicks})
+}
+
+// 获取在线用户
+func (h *NodeHandler) GetOnlineUsers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"online": h.onlineService.GetOnlineUsers()})
+}
+
+// 获取节点凭证信息
+func (h *NodeHandler) GetNodeCredential(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	credential, err := h.nodeService.GetNodeCredential(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"credential": credential})
+}
+
+// 轮换节点令牌
+func (h *NodeHandler) RotateNodeToken(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	token, err := h.nodeService.RotateNodeToken(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点令牌已更新", "token": token})
+}
+
+// 更新节点IP白名单
+func (h *NodeHandler) UpdateNodeAllowedIPs(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req struct {
+		AllowedIPs []string `json:"allowed_ips"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.nodeService.UpdateNodeAllowedIPs(uint(nodeID), req.AllowedIPs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "节点白名单更新成功"})
+}
+
+// 获取节点故障记录，不指定节点ID时返回所有节点的记录
+func (h *NodeHandler) GetNodeIncidents(c *gin.Context) {
+	var nodeID uint64
+	if v := c.Query("node_id"); v != "" {
+		var err error
+		nodeID, err = strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	incidents, err := h.nodeService.GetNodeIncidents(uint(nodeID), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"incidents": incidents})
+}
+
+// 获取节点探测记录
+func (h *NodeHandler) GetNodeProbes(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	probes, err := h.probeService.GetNodeProbes(uint(nodeID), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"probes": probes})
+}
+
+// 节点或分组分流配置请求
+type routingRequest struct {
+	ACL       string                `json:"acl"`
+	Outbounds []models.NodeOutbound `json:"outbounds"`
+}
+
+// 获取节点的服务端分流配置
+func (h *NodeHandler) GetNodeRouting(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	routing, err := h.nodeService.GetNodeRouting(uint(nodeID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"acl": routing.ACL, "outbounds": routing.Outbounds})
+}
+
+// 设置节点的服务端分流配置
+func (h *NodeHandler) SetNodeRouting(c *gin.Context) {
+	nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+		return
+	}
+
+	var req routingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.nodeService.SetNodeRouting(uint(nodeID), req.ACL, req.Outbounds); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "分流配置更新成功"})
+}
+
+// 获取节点分组的服务端分流配置
+func (h *NodeHandler) GetGroupRouting(c *gin.Context) {
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分组ID"})
+		return
+	}
+
+	routing, err := h.nodeService.GetGroupRouting(uint(groupID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"acl": routing.ACL, "outbounds": routing.Outbounds})
+}
+
+// 设置节点分组的服务端分流配置
+func (h *NodeHandler) SetGroupRouting(c *gin.Context) {
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分组ID"})
+		return
+	}
+
+	var req routingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.nodeService.SetGroupRouting(uint(groupID), req.ACL, req.Outbounds); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "分流配置更新成功"})
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCreateNodeValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"缺少地址", `{"name":"hk-1","port":443}`},
+		{"无效端口", `{"name":"hk-1","host":"hk.example.com","port":70000}`},
+		{"端口跳跃间隔过短", `{"name":"hk-1","host":"hk.example.com","port":443,"hop_ports":"20000-30000","hop_interval":3}`},
+		{"带宽缺少单位", `{"name":"hk-1","host":"hk.example.com","port":443,"bandwidth_up":"100"}`},
+		{"无效的伪装类型", `{"name":"hk-1","host":"hk.example.com","port":443,"masquerade":{"Type":"redirect"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/nodes", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			// 校验失败时不会访问数据库
+			NewNodeHandler(nil, nil, nil).CreateNode(c)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400, body = %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// 请求中的状态、统计密钥、混淆密码等面板维护的字段不会写入节点
+func TestCreateNodeRequestIgnoresManagedFields(t *testing.T) {
+	body := `{"name":"hk-1","host":"hk.example.com","port":443,"group_id":2,"fast_open":true,
+		"Status":2,"StatsSecret":"secret","ObfsPassword":"obfs","TotalUpload":100,"PinnedConfigVersion":3,"ID":9}`
+
+	var req createNodeRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	node := req.node()
+
+	if node.Name != "hk-1" || node.Host != "hk.example.com" || node.Port != 443 || node.GroupID != 2 || !node.FastOpen {
+		t.Errorf("可设置的字段未写入: %+v", node)
+	}
+	if node.ID != 0 || node.Status != 0 || node.StatsSecret != "" || node.ObfsPassword != "" ||
+		node.TotalUpload != 0 || node.PinnedConfigVersion != 0 {
+		t.Errorf("面板维护的字段不应从请求写入: %+v", node)
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrderHandler struct {
+	orderService   *services.OrderService
+	invoiceService *services.InvoiceService
+}
+
+func NewOrderHandler(orderService *services.OrderService, invoiceService *services.InvoiceService) *OrderHandler {
+	return &OrderHandler{
+		orderService:   orderService,
+		invoiceService: invoiceService,
+	}
+}
+
+// 获取订单列表（支持按用户、套餐、订单号、状态、时间范围搜索），普通用户只能查看自己的订单
+func (h *OrderHandler) GetOrders(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+
+	query := &services.OrderQuery{
+		Page:          page,
+		PageSize:      pageSize,
+		OrderNo:       c.Query("order_no"),
+		PaymentMethod: c.Query("payment_method"),
+	}
+
+	if v := c.Query("user_id"); v != "" {
+		userID, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+			return
+		}
+		query.UserID = uint(userID)
+	}
+	if v := c.Query("plan_id"); v != "" {
+		planID, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的套餐ID"})
+			return
+		}
+		query.PlanID = uint(planID)
+	}
+	if v := c.Query("status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的订单状态"})
+			return
+		}
+		query.PaymentStatus = &status
+	}
+	if v := c.Query("start"); v != "" {
+		start, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的开始日期"})
+			return
+		}
+		query.StartAt = start
+	}
+	if v := c.Query("end"); v != "" {
+		end, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的结束日期"})
+			return
+		}
+		query.EndAt = end.AddDate(0, 0, 1)
+	}
+	if !c.GetBool("isAdmin") {
+		query.UserID = c.GetUint("userID")
+	}
+
+	orders, total, err := h.orderService.GetOrders(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders": orders,
+		"total":  total,
+		"page":   page,
+		"size":   pageSize,
+	})
+}
+
+// 获取订单详情
+func (h *OrderHandler) GetOrder(c *gin.Context) {
+	order, err := h.orderService.GetOrderByNo(c.Param("no"))
+	if err != nil || !canViewOrder(c, order.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order": order})
+}
+
+// 取消订单
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	if err := h.orderService.CancelOrder(userID, c.Param("no")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "订单已取消"})
+}
+
+// 下载订单发票，format 可选 pdf 或 html
+func (h *OrderHandler) DownloadInvoice(c *gin.Context) {
+	order, err := h.orderService.GetOrderByNo(c.Param("no"))
+	if err != nil || !canViewOrder(c, order.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		return
+	}
+
+	invoice, err := h.invoiceService.GetInvoiceByOrderNo(c.Param("no"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch c.DefaultQuery("format", "pdf") {
+	case "pdf":
+		if invoice.PDFPath == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未生成PDF发票，请使用html格式"})
+			return
+		}
+		c.FileAttachment(invoice.PDFPath, invoice.InvoiceNo+".pdf")
+	case "html":
+		if invoice.HTMLPath == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "发票文件不存在"})
+			return
+		}
+		c.FileAttachment(invoice.HTMLPath, invoice.InvoiceNo+".html")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的发票格式"})
+	}
+}
+
+// 管理员可以查看所有订单，普通用户只能查看自己的订单
+func canViewOrder(c *gin.Context, orderUserID uint) bool {
+	return c.GetBool("isAdmin") || orderUserID == c.GetUint("userID")
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 创建只生成 SQL、不连接数据库的 gorm 实例，返回记录生成语句的切片
+func newDryRunDB(t *testing.T) (*gorm.DB, *[]string) {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "test:test@tcp(127.0.0.1:3306)/test?parseTime=True",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statements []string
+	record := func(tx *gorm.DB) {
+		statements = append(statements, tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...))
+	}
+	db.Callback().Query().After("gorm:query").Register("test:record", record)
+	db.Callback().Update().After("gorm:update").Register("test:record", record)
+	return db, &statements
+}
+
+// 创建带有登录用户信息的请求上下文
+func newUserContext(method, target string, userID uint, isAdmin bool) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, nil)
+	c.Set("userID", userID)
+	c.Set("isAdmin", isAdmin)
+	return c, w
+}
+
+func TestGetOrdersScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		userID  uint
+		isAdmin bool
+		want    string // 查询条件中应包含的用户条件，为空表示不按用户过滤
+	}{
+		{"普通用户只查询自己的订单", "/api/orders", 7, false, "user_id = 7"},
+		{"普通用户不能指定其他用户", "/api/orders?user_id=9", 7, false, "user_id = 7"},
+		{"管理员按用户搜索", "/api/orders?user_id=9", 1, true, "user_id = 9"},
+		{"管理员查询所有订单", "/api/orders", 1, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, statements := newDryRunDB(t)
+			h := NewOrderHandler(services.NewOrderService(db), nil)
+
+			c, w := newUserContext(http.MethodGet, tt.target, tt.userID, tt.isAdmin)
+			h.GetOrders(c)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+			}
+			if len(*statements) == 0 {
+				t.Fatal("没有生成查询语句")
+			}
+			for _, sql := range *statements {
+				if tt.want == "" && strings.Contains(sql, "user_id") {
+					t.Errorf("查询不应按用户过滤: %s", sql)
+				}
+				if tt.want != "" && !strings.Contains(sql, tt.want) {
+					t.Errorf("查询缺少条件 %q: %s", tt.want, sql)
+				}
+			}
+		})
+	}
+}
+
+func TestCancelOrderUsesTokenUser(t *testing.T) {
+	db, statements := newDryRunDB(t)
+	h := NewOrderHandler(services.NewOrderService(db), nil)
+
+	c, _ := newUserContext(http.MethodPost, "/api/orders/NO1/cancel", 7, false)
+	c.Params = gin.Params{{Key: "no", Value: "NO1"}}
+	h.CancelOrder(c)
+
+	if len(*statements) != 1 || !strings.Contains((*statements)[0], "user_id = 7") {
+		t.Errorf("取消订单应限定为当前用户: %v", *statements)
+	}
+}
+
+func TestCanViewOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		userID      uint
+		isAdmin     bool
+		orderUserID uint
+		want        bool
+	}{
+		{"自己的订单", 7, false, 7, true},
+		{"其他用户的订单", 7, false, 9, false},
+		{"管理员查看其他用户的订单", 1, true, 9, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newUserContext(http.MethodGet, "/", tt.userID, tt.isAdmin)
+			if got := canViewOrder(c, tt.orderUserID); got != tt.want {
+				t.Errorf("canViewOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -85,3 +85,8 @@ func (h *PaymentHandler) QueryPaymentStatus(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"paid": paid})
 }
+
+// 获取支付方式列表
+func (h *PaymentHandler) GetPaymentMethods(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"methods": h.paymentService.GetPaymentMethods()})
+}
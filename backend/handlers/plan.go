@@ -67,6 +67,52 @@ func (h *PlanHandler) UpdatePlan(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "套餐更新成功"})
 }
 
+// 设置套餐多币种价格
+func (h *PlanHandler) SetPlanPrices(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的套餐ID"})
+		return
+	}
+
+	var prices []models.PlanPrice
+	if err := c.ShouldBindJSON(&prices); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.planService.SetPlanPrices(uint(id), prices); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "套餐价格更新成功"})
+}
+
+// 设置套餐可使用的节点分组
+func (h *PlanHandler) SetPlanNodeGroups(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的套餐ID"})
+		return
+	}
+
+	var req struct {
+		GroupIDs []uint `json:"group_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.planService.SetPlanNodeGroups(uint(id), req.GroupIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "套餐节点分组更新成功"})
+}
+
 // 订阅套餐
 func (h *PlanHandler) Subscribe(c *gin.Context) {
 	planID, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -75,8 +121,7 @@ func (h *PlanHandler) Subscribe(c *gin.Context) {
 		return
 	}
 
-	// 从JWT中获取用户ID
-	userID := uint(1) // TODO: 从JWT中获取
+	userID := c.GetUint("userID")
 
 	if err := h.planService.Subscribe(userID, uint(planID)); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -94,10 +139,9 @@ func (h *PlanHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	// 从JWT中获取用户ID
-	userID := uint(1) // TODO: 从JWT中获取
+	userID := c.GetUint("userID")
 
-	order, err := h.planService.CreateOrder(userID, uint(planID))
+	order, err := h.planService.CreateOrder(userID, uint(planID), c.Query("currency"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -11,10 +11,14 @@ import (
 
 type SettingHandler struct {
 	settingService *services.SettingService
+	configManager  *services.ConfigManagerService
 }
 
-func NewSettingHandler(settingService *services.SettingService) *SettingHandler {
-	return &SettingHandler{settingService: settingService}
+func NewSettingHandler(settingService *services.SettingService, configManager *services.ConfigManagerService) *SettingHandler {
+	return &SettingHandler{
+		settingService: settingService,
+		configManager:  configManager,
+	}
 }
 
 // 获取TLS配置
@@ -99,3 +103,171 @@ func (h *SettingHandler) UpdateAnnouncement(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "系统公告更新成功"})
 }
+
+// 获取订单配置
+func (h *SettingHandler) GetOrderConfig(c *gin.Context) {
+	config, err := h.settingService.GetOrderConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": config})
+}
+
+// 更新订单配置
+func (h *SettingHandler) UpdateOrderConfig(c *gin.Context) {
+	var config models.OrderConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.settingService.UpdateOrderConfig(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "订单配置更新成功"})
+}
+
+// 获取发票配置
+func (h *SettingHandler) GetInvoiceConfig(c *gin.Context) {
+	config, err := h.settingService.GetInvoiceConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": config})
+}
+
+// 更新发票配置
+func (h *SettingHandler) UpdateInvoiceConfig(c *gin.Context) {
+	var config models.InvoiceConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.settingService.UpdateInvoiceConfig(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "发票配置更新成功"})
+}
+
+// 获取币种与汇率配置
+func (h *SettingHandler) GetCurrencyConfig(c *gin.Context) {
+	config, err := h.settingService.GetCurrencyConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": config})
+}
+
+// 更新币种与汇率配置
+func (h *SettingHandler) UpdateCurrencyConfig(c *gin.Context) {
+	var config models.CurrencyConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.settingService.UpdateCurrencyConfig(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "币种配置更新成功"})
+}
+
+// 获取用户配置自动分配设置
+func (h *SettingHandler) GetProvisionConfig(c *gin.Context) {
+	config, err := h.settingService.GetProvisionConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": config})
+}
+
+// 更新用户配置自动分配设置
+func (h *SettingHandler) UpdateProvisionConfig(c *gin.Context) {
+	var config models.ProvisionConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.settingService.UpdateProvisionConfig(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 启用或关闭独立端口后为已有用户分配或释放端口
+	if err := h.configManager.SyncUserPorts(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "自动分配设置更新成功"})
+}
+
+// 获取告警配置
+func (h *SettingHandler) GetAlertConfig(c *gin.Context) {
+	config, err := h.settingService.GetAlertConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": config})
+}
+
+// 更新告警配置
+func (h *SettingHandler) UpdateAlertConfig(c *gin.Context) {
+	var config models.AlertConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.settingService.UpdateAlertConfig(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "告警配置更新成功"})
+}
+
+// 获取分流规则模板配置
+func (h *SettingHandler) GetRoutingConfig(c *gin.Context) {
+	config, err := h.settingService.GetRoutingConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": config})
+}
+
+// 更新分流规则模板配置
+func (h *SettingHandler) UpdateRoutingConfig(c *gin.Context) {
+	var config models.RoutingConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if err := h.settingService.UpdateRoutingConfig(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "分流规则配置更新成功"})
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"hysteria2-panel/models"
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatsHandler struct {
+	statsService *services.StatsService
+}
+
+func NewStatsHandler(statsService *services.StatsService) *StatsHandler {
+	return &StatsHandler{statsService: statsService}
+}
+
+// 收入统计，group 可选 day、month、plan、method
+func (h *StatsHandler) GetRevenue(c *gin.Context) {
+	start, end, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.statsService.GetRevenue(c.DefaultQuery("group", "day"), start, end)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := [][]string{{"key", "currency", "orders", "amount"}}
+		for _, s := range stats {
+			rows = append(rows, []string{s.Key, s.Currency, strconv.FormatInt(s.Orders, 10), models.FormatAmount(s.Amount, s.Currency)})
+		}
+		writeCSV(c, "revenue.csv", rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// 新客户与续费客户统计，group 可选 day、month
+func (h *StatsHandler) GetCustomers(c *gin.Context) {
+	start, end, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.statsService.GetCustomers(c.DefaultQuery("group", "month"), start, end)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := [][]string{{"period", "new", "renewing", "customers"}}
+		for _, s := range stats {
+			rows = append(rows, []string{s.Period, strconv.FormatInt(s.New, 10), strconv.FormatInt(s.Renewing, 10), strconv.FormatInt(s.Customers, 10)})
+		}
+		writeCSV(c, "customers.csv", rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// 流失统计，group 可选 day、month
+func (h *StatsHandler) GetChurn(c *gin.Context) {
+	start, end, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.statsService.GetChurn(c.DefaultQuery("group", "month"), start, end)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := [][]string{{"period", "expired", "renewed", "churned", "churn_rate"}}
+		for _, s := range stats {
+			rows = append(rows, []string{
+				s.Period,
+				strconv.FormatInt(s.Expired, 10),
+				strconv.FormatInt(s.Renewed, 10),
+				strconv.FormatInt(s.Churned, 10),
+				fmt.Sprintf("%.2f", s.ChurnRate),
+			})
+		}
+		writeCSV(c, "churn.csv", rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// 每付费用户平均收入统计
+func (h *StatsHandler) GetARPU(c *gin.Context) {
+	start, end, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.statsService.GetARPU(start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := [][]string{{"currency", "revenue", "payers", "arpu"}}
+		for _, s := range stats {
+			rows = append(rows, []string{
+				s.Currency,
+				models.FormatAmount(s.Revenue, s.Currency),
+				strconv.FormatInt(s.Payers, 10),
+				models.FormatAmount(int64(s.ARPU), s.Currency),
+			})
+		}
+		writeCSV(c, "arpu.csv", rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// 活跃订阅数趋势
+func (h *StatsHandler) GetActiveSubscribers(c *gin.Context) {
+	start, end, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+	if end.Sub(start) > 366*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不能超过一年"})
+		return
+	}
+
+	stats, err := h.statsService.GetActiveSubscribers(start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := [][]string{{"date", "active"}}
+		for _, s := range stats {
+			rows = append(rows, []string{s.Date, strconv.FormatInt(s.Active, 10)})
+		}
+		writeCSV(c, "active_subscribers.csv", rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// 解析 start、end 日期参数（包含结束日期当天），默认最近30天
+func parseDateRange(c *gin.Context) (time.Time, time.Time, bool) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	start := today.AddDate(0, 0, -29)
+	end := today.AddDate(0, 0, 1)
+
+	if v := c.Query("start"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的开始日期"})
+			return start, end, false
+		}
+		start = t
+	}
+	if v := c.Query("end"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的结束日期"})
+			return start, end, false
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+	if !start.Before(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "开始日期不能晚于结束日期"})
+		return start, end, false
+	}
+
+	return start, end, true
+}
+
+// 以CSV附件形式输出
+func writeCSV(c *gin.Context, filename string, rows [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.WriteAll(rows)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubscriptionHandler struct {
+	subscriptionService *services.SubscriptionService
+}
+
+func NewSubscriptionHandler(subscriptionService *services.SubscriptionService) *SubscriptionHandler {
+	return &SubscriptionHandler{subscriptionService: subscriptionService}
+}
+
+// 获取用户订阅链接
+func (h *SubscriptionHandler) GetSubLink(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+
+	token, err := h.subscriptionService.GetSubToken(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "path": "/sub/" + token})
+}
+
+// 重置订阅链接和节点认证密码
+func (h *SubscriptionHandler) ResetSubscription(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+
+	token, err := h.subscriptionService.ResetSubscription(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "订阅已重置", "token": token, "path": "/sub/" + token})
+}
+
+// 获取订阅拉取记录
+func (h *SubscriptionHandler) GetSubscriptionFetches(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	fetches, err := h.subscriptionService.GetSubscriptionFetches(userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"fetches": fetches})
+}
+
+// 获取订阅内容（公开接口，通过令牌识别用户）
+// 格式由 target 参数指定：links、clash、singbox、hysteria2，未指定时根据 User-Agent 判断
+func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
+	var nodeID uint64
+	if v := c.Query("node"); v != "" {
+		var err error
+		nodeID, err = strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+			return
+		}
+	}
+
+	content, err := h.subscriptionService.GetSubscription(c.Param("token"), c.Query("target"), c.GetHeader("User-Agent"), c.ClientIP(), uint(nodeID))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSubscriptionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrSubscriptionTarget):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Header("Subscription-Userinfo", content.UserInfo)
+	c.Header("Profile-Update-Interval", "24")
+	c.Data(http.StatusOK, content.ContentType, content.Body)
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 普通用户不能获取、重置其他用户的订阅链接或查看其拉取记录
+func TestSubscriptionForbidsOtherUsers(t *testing.T) {
+	h := NewSubscriptionHandler(nil)
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		handler gin.HandlerFunc
+	}{
+		{"获取订阅链接", http.MethodGet, "/api/users/9/sub", h.GetSubLink},
+		{"重置订阅", http.MethodPost, "/api/users/9/sub/reset", h.ResetSubscription},
+		{"拉取记录", http.MethodGet, "/api/users/9/sub/fetches", h.GetSubscriptionFetches},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := newUserContext(tt.method, tt.target, 7, false)
+			c.Params = gin.Params{{Key: "id", Value: "9"}}
+
+			tt.handler(c)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+}
@@ -16,12 +16,12 @@ func NewTrafficHandler(trafficService *services.TrafficService) *TrafficHandler
 	return &TrafficHandler{trafficService: trafficService}
 }
 
-// 记录流量使用
+// 记录节点上报的流量使用，节点ID取自节点认证
 func (h *TrafficHandler) RecordTraffic(c *gin.Context) {
 	type TrafficRecord struct {
 		UserID   uint  `json:"user_id" binding:"required"`
-		Upload   int64 `json:"upload" binding:"required"`
-		Download int64 `json:"download" binding:"required"`
+		Upload   int64 `json:"upload"`
+		Download int64 `json:"download"`
 	}
 
 	var record TrafficRecord
@@ -30,7 +30,8 @@ func (h *TrafficHandler) RecordTraffic(c *gin.Context) {
 		return
 	}
 
-	if err := h.trafficService.RecordTraffic(record.UserID, record.Upload, record.Download); err != nil {
+	nodeID := c.GetUint("nodeID")
+	if err := h.trafficService.RecordTraffic(nodeID, record.UserID, record.Upload, record.Download); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -61,3 +61,18 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "用户删除成功"})
 }
+
+// 解析路径中的用户ID，普通用户只能访问自己的数据，管理员可以访问任意用户。
+// 解析失败或无权访问时写入错误响应并返回 false
+func pathUserID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return 0, false
+	}
+	if !c.GetBool("isAdmin") && uint(id) != c.GetUint("userID") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该用户"})
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPathUserID(t *testing.T) {
+	tests := []struct {
+		name     string
+		param    string
+		userID   uint
+		isAdmin  bool
+		wantOK   bool
+		wantCode int
+	}{
+		{"访问自己", "7", 7, false, true, http.StatusOK},
+		{"访问其他用户", "9", 7, false, false, http.StatusForbidden},
+		{"管理员访问其他用户", "9", 1, true, true, http.StatusOK},
+		{"无效的用户ID", "abc", 7, false, false, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := newUserContext(http.MethodGet, "/api/users/"+tt.param+"/nodes", tt.userID, tt.isAdmin)
+			c.Params = gin.Params{{Key: "id", Value: tt.param}}
+
+			id, ok := pathUserID(c)
+			if ok != tt.wantOK {
+				t.Fatalf("pathUserID() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && id == 0 {
+				t.Error("允许访问时应返回用户ID")
+			}
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// 普通用户不能查看其他用户可用的节点
+func TestGetUserNodesForbidsOtherUsers(t *testing.T) {
+	c, w := newUserContext(http.MethodGet, "/api/users/9/nodes", 7, false)
+	c.Params = gin.Params{{Key: "id", Value: "9"}}
+
+	NewNodeHandler(nil, nil, nil).GetUserNodes(c)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
@@ -26,6 +26,11 @@ func main() {
 		Config: loadConfig(),
 	}
 
+	// 只信任配置中的反向代理，避免伪造 X-Forwarded-For 绕过节点IP白名单
+	if err := server.Router.SetTrustedProxies(server.Config.TrustedProxies); err != nil {
+		panic(err)
+	}
+
 	// 初始化数据库
 	initDB(server)
 
@@ -54,31 +59,49 @@ func initDB(server *Server) {
 
 func setupRoutes(server *Server) {
 	// 创建服务实例
-	userService := services.NewUserService(server.DB)
+	settingService := services.NewSettingService(server.DB)
+	configManager := services.NewConfigManagerService(server.DB, settingService)
+	userService := services.NewUserService(server.DB, configManager)
 	userManager := services.NewUserManagerService(server.DB)
-	configManager := services.NewConfigManagerService(server.DB)
 	hy2Service := services.NewHysteria2Service(
 		"configs/hysteria2",
 		server.Config.TLSCertPath,
 		server.Config.TLSKeyPath,
 	)
 	trafficService := services.NewTrafficService(server.DB)
-	nodeService := services.NewNodeService(server.DB)
-	nodeHandler := handlers.NewNodeHandler(nodeService)
-	settingService := services.NewSettingService(server.DB)
+	settingHandler := handlers.NewSettingHandler(settingService, configManager)
 	mailService := services.NewMailService(settingService)
+	notificationService := services.NewNotificationService(server.DB, mailService, settingService)
+	nodeService := services.NewNodeService(server.DB, notificationService)
+	onlineService := services.NewOnlineService(server.DB)
+	probeService := services.NewProbeService(server.DB, notificationService)
+	nodeHandler := handlers.NewNodeHandler(nodeService, onlineService, probeService)
+	configVersionService := services.NewConfigVersionService(server.DB, nodeService, hy2Service)
+	configVersionHandler := handlers.NewConfigVersionHandler(configVersionService)
+	deployService := services.NewDeployService(server.DB, nodeService, configVersionService, server.Config.TLSCertPath, server.Config.TLSKeyPath)
+	deployHandler := handlers.NewDeployHandler(deployService)
+	installerService := services.NewInstallerService(nodeService, hy2Service, "agent")
+	installerHandler := handlers.NewInstallerHandler(installerService)
 	certService := services.NewCertService(settingService, "certs")
-	planService := services.NewPlanService(server.DB)
+	currencyService := services.NewCurrencyService(server.DB, settingService)
+	currencyHandler := handlers.NewCurrencyHandler(currencyService)
+	planService := services.NewPlanService(server.DB, settingService, currencyService, configManager)
 	planHandler := handlers.NewPlanHandler(planService)
-	notificationService := services.NewNotificationService(server.DB, mailService)
-	paymentService := services.NewPaymentService(server.DB, settingService, planService)
+	orderService := services.NewOrderService(server.DB)
+	invoiceService := services.NewInvoiceService(server.DB, settingService, mailService, "invoices")
+	orderHandler := handlers.NewOrderHandler(orderService, invoiceService)
+	statsService := services.NewStatsService(server.DB)
+	statsHandler := handlers.NewStatsHandler(statsService)
+	paymentService := services.NewPaymentService(server.DB, settingService, planService, invoiceService)
 	paymentHandler := handlers.NewPaymentHandler(paymentService)
+	subscriptionService := services.NewSubscriptionService(server.DB, nodeService, configManager, hy2Service, trafficService, onlineService, notificationService, settingService, deployService)
+	subscriptionHandler := handlers.NewSubscriptionHandler(subscriptionService)
 
 	// 创建处理器
 	authHandler := handlers.NewAuthHandler(userService)
 	userHandler := handlers.NewUserHandler(userManager)
 	configHandler := handlers.NewConfigHandler(configManager)
-	hy2Handler := handlers.NewHysteria2Handler(configManager, hy2Service)
+	hy2Handler := handlers.NewHysteria2Handler(configManager, hy2Service, nodeService)
 	trafficHandler := handlers.NewTrafficHandler(trafficService)
 
 	// 用户认证相关路由
@@ -96,6 +119,10 @@ func setupRoutes(server *Server) {
 		api.GET("/users", userHandler.GetUsers)
 		api.PUT("/users/:id", userHandler.UpdateUser)
 		api.DELETE("/users/:id", userHandler.DeleteUser)
+		api.GET("/users/:id/nodes", nodeHandler.GetUserNodes)
+		api.GET("/users/:id/sub", subscriptionHandler.GetSubLink)
+		api.POST("/users/:id/sub/reset", subscriptionHandler.ResetSubscription)
+		api.GET("/users/:id/sub/fetches", subscriptionHandler.GetSubscriptionFetches)
 
 		// 配置管理
 		api.GET("/configs/:id", configHandler.GetUserConfig)
@@ -104,40 +131,139 @@ func setupRoutes(server *Server) {
 		// 添加 Hysteria2 配置相关路由
 		api.GET("/hy2/server/:id", hy2Handler.GenerateServerConfig)
 		api.GET("/hy2/client/:id", hy2Handler.GetClientConfig)
+		api.GET("/hy2/share/:id", hy2Handler.GetShareURI)
+		api.GET("/hy2/qrcode/:id", hy2Handler.GetShareQRCode)
+		api.POST("/hy2/parse", hy2Handler.ParseShareURI)
 
 		// 添加流量统计相关路由
-		api.POST("/traffic/record", trafficHandler.RecordTraffic)
 		api.GET("/traffic/check/:id", trafficHandler.CheckTrafficLimit)
 
-		// 添加节点管理相关路由
-		api.POST("/nodes", nodeHandler.CreateNode)
-		api.GET("/nodes", nodeHandler.GetNodes)
-		api.POST("/nodes/:id/status", nodeHandler.UpdateNodeStatus)
-		api.GET("/nodes/:id/status", nodeHandler.GetNodeStatus)
-
 		// 添加系统设置相关路由
-		api.GET("/settings/tls", settingHandler.GetTLSConfig)
-		api.PUT("/settings/tls", settingHandler.UpdateTLSConfig)
-		api.GET("/settings/smtp", settingHandler.GetSMTPConfig)
-		api.PUT("/settings/smtp", settingHandler.UpdateSMTPConfig)
 		api.GET("/settings/announcement", settingHandler.GetAnnouncement)
-		api.PUT("/settings/announcement", settingHandler.UpdateAnnouncement)
 
-		// 添加套餐管理相关路由
-		api.POST("/plans", planHandler.CreatePlan)
+		// 添加汇率相关路由
+		api.GET("/currency/rates", currencyHandler.GetRates)
+
+		// 添加套餐相关路由
 		api.GET("/plans", planHandler.GetPlans)
-		api.PUT("/plans/:id", planHandler.UpdatePlan)
 		api.POST("/plans/:id/subscribe", planHandler.Subscribe)
 		api.POST("/plans/:id/order", planHandler.CreateOrder)
 
+		// 添加订单管理相关路由
+		api.GET("/orders", orderHandler.GetOrders)
+		api.GET("/orders/:no", orderHandler.GetOrder)
+		api.POST("/orders/:no/cancel", orderHandler.CancelOrder)
+		api.GET("/orders/:no/invoice", orderHandler.DownloadInvoice)
+
 		// 添加支付相关路由
 		api.POST("/payments", paymentHandler.CreatePayment)
+		api.GET("/payments/methods", paymentHandler.GetPaymentMethods)
 		api.GET("/payments/status", paymentHandler.QueryPaymentStatus)
 	}
 
+	// 管理员接口
+	admin := server.Router.Group("/api")
+	admin.Use(middleware.AuthRequired(), middleware.AdminRequired())
+	{
+		// 系统设置
+		admin.GET("/settings/tls", settingHandler.GetTLSConfig)
+		admin.PUT("/settings/tls", settingHandler.UpdateTLSConfig)
+		admin.GET("/settings/smtp", settingHandler.GetSMTPConfig)
+		admin.PUT("/settings/smtp", settingHandler.UpdateSMTPConfig)
+		admin.PUT("/settings/announcement", settingHandler.UpdateAnnouncement)
+		admin.GET("/settings/order", settingHandler.GetOrderConfig)
+		admin.PUT("/settings/order", settingHandler.UpdateOrderConfig)
+		admin.GET("/settings/invoice", settingHandler.GetInvoiceConfig)
+		admin.PUT("/settings/invoice", settingHandler.UpdateInvoiceConfig)
+		admin.GET("/settings/currency", settingHandler.GetCurrencyConfig)
+		admin.PUT("/settings/currency", settingHandler.UpdateCurrencyConfig)
+		admin.GET("/settings/alert", settingHandler.GetAlertConfig)
+		admin.PUT("/settings/alert", settingHandler.UpdateAlertConfig)
+		admin.GET("/settings/routing", settingHandler.GetRoutingConfig)
+		admin.PUT("/settings/routing", settingHandler.UpdateRoutingConfig)
+		admin.GET("/settings/provision", settingHandler.GetProvisionConfig)
+		admin.PUT("/settings/provision", settingHandler.UpdateProvisionConfig)
+
+		// 汇率管理
+		admin.PUT("/currency/rates", currencyHandler.SetRate)
+		admin.DELETE("/currency/rates", currencyHandler.DeleteRate)
+		admin.POST("/currency/rates/fetch", currencyHandler.FetchRates)
+
+		// 套餐管理
+		admin.POST("/plans", planHandler.CreatePlan)
+		admin.PUT("/plans/:id", planHandler.UpdatePlan)
+		admin.PUT("/plans/:id/prices", planHandler.SetPlanPrices)
+		admin.PUT("/plans/:id/node-groups", planHandler.SetPlanNodeGroups)
+
+		// 节点管理
+		admin.POST("/nodes", nodeHandler.CreateNode)
+		admin.GET("/nodes", nodeHandler.GetNodes)
+		admin.PUT("/nodes/:id", nodeHandler.UpdateNode)
+		admin.DELETE("/nodes/:id", nodeHandler.DeleteNode)
+		admin.PUT("/nodes/:id/maintenance", nodeHandler.SetNodeMaintenance)
+		admin.PUT("/nodes/:id/obfs", nodeHandler.SetNodeObfs)
+		admin.POST("/nodes/batch", nodeHandler.BatchNodes)
+		admin.GET("/node-groups", nodeHandler.GetNodeGroups)
+		admin.POST("/node-groups", nodeHandler.CreateNodeGroup)
+		admin.PUT("/node-groups/:id", nodeHandler.UpdateNodeGroup)
+		admin.DELETE("/node-groups/:id", nodeHandler.DeleteNodeGroup)
+		admin.GET("/node-groups/:id/routing", nodeHandler.GetGroupRouting)
+		admin.PUT("/node-groups/:id/routing", nodeHandler.SetGroupRouting)
+		admin.GET("/nodes/:id/routing", nodeHandler.GetNodeRouting)
+		admin.PUT("/nodes/:id/routing", nodeHandler.SetNodeRouting)
+		admin.GET("/nodes/:id/status", nodeHandler.GetNodeStatus)
+		admin.GET("/nodes/:id/probes", nodeHandler.GetNodeProbes)
+		admin.GET("/nodes/:id/port-hopping", hy2Handler.GetPortHoppingRules)
+		admin.GET("/nodes/:id/token", nodeHandler.GetNodeCredential)
+		admin.POST("/nodes/:id/token", nodeHandler.RotateNodeToken)
+		admin.PUT("/nodes/:id/token/ips", nodeHandler.UpdateNodeAllowedIPs)
+		admin.GET("/nodes/:id/ssh", deployHandler.GetNodeSSH)
+		admin.PUT("/nodes/:id/ssh", deployHandler.SetNodeSSH)
+		admin.POST("/nodes/:id/deploy", deployHandler.Deploy)
+		admin.GET("/nodes/:id/deployments", deployHandler.GetDeployments)
+		admin.GET("/deployments/:id", deployHandler.GetDeployment)
+		admin.POST("/nodes/:id/install", installerHandler.GenerateNodeInstall)
+		admin.GET("/nodes/:id/config-versions", configVersionHandler.GetVersions)
+		admin.GET("/nodes/:id/config-versions/:version", configVersionHandler.GetVersion)
+		admin.GET("/nodes/:id/config-diff", configVersionHandler.DiffVersions)
+		admin.PUT("/nodes/:id/config-pin", configVersionHandler.PinVersion)
+		admin.POST("/nodes/:id/config-rollback", configVersionHandler.Rollback)
+		admin.GET("/online", nodeHandler.GetOnlineUsers)
+		admin.GET("/incidents", nodeHandler.GetNodeIncidents)
+
+		// 收入与销售统计
+		admin.GET("/stats/revenue", statsHandler.GetRevenue)
+		admin.GET("/stats/customers", statsHandler.GetCustomers)
+		admin.GET("/stats/churn", statsHandler.GetChurn)
+		admin.GET("/stats/arpu", statsHandler.GetARPU)
+		admin.GET("/stats/subscribers", statsHandler.GetActiveSubscribers)
+	}
+
+	// 节点接口（使用节点令牌认证）
+	node := server.Router.Group("/api/nodes/:id")
+	node.Use(middleware.NodeAuthRequired(nodeService))
+	{
+		node.POST("/register", nodeHandler.RegisterNode)
+		node.POST("/status", nodeHandler.UpdateNodeStatus)
+		node.GET("/config", configVersionHandler.GetNodeConfig)
+		node.POST("/config/running", configVersionHandler.ReportRunning)
+		node.GET("/instances", configVersionHandler.GetNodeInstances)
+		node.GET("/cert", hy2Handler.GetNodeCertificate)
+		node.GET("/users", nodeHandler.GetNodeUsers)
+		node.POST("/online", nodeHandler.ReportOnline)
+		node.POST("/traffic", trafficHandler.RecordTraffic)
+	}
+
 	// 支付回调接口（不需要认证）
 	server.Router.POST("/api/callback/:method", paymentHandler.HandleCallback)
 
+	// 节点安装脚本和节点代理下载（不需要认证，节点令牌通过环境变量传入）
+	server.Router.GET("/install/node.sh", installerHandler.GetInstallScript)
+	server.Router.GET("/install/agent/:arch", installerHandler.GetAgentBinary)
+
+	// 订阅接口（通过订阅令牌认证）
+	server.Router.GET("/sub/:token", subscriptionHandler.GetSubscription)
+
 	// 初始化证书
 	if err := certService.ObtainCert(); err != nil {
 		log.Printf("初始化证书失败: %v", err)
@@ -151,6 +277,16 @@ func setupRoutes(server *Server) {
 		expirationTicker := time.NewTicker(12 * time.Hour)
 		// 流量提醒检查
 		trafficTicker := time.NewTicker(6 * time.Hour)
+		// 订单对账与过期检查
+		orderTicker := time.NewTicker(5 * time.Minute)
+		// 汇率更新
+		rateTicker := time.NewTicker(24 * time.Hour)
+		// 节点状态历史降采样，清理订阅拉取记录
+		nodeStatusTicker := time.NewTicker(time.Hour)
+		// 节点离线检查
+		nodeCheckTicker := time.NewTicker(time.Minute)
+		// 节点可达性探测
+		probeTicker := time.NewTicker(5 * time.Minute)
 
 		for {
 			select {
@@ -166,6 +302,33 @@ func setupRoutes(server *Server) {
 				if err := notificationService.SendTrafficNotices(); err != nil {
 					log.Printf("发送流量提醒失败: %v", err)
 				}
+			case <-orderTicker.C:
+				// 先对账再过期，避免已支付但回调丢失的订单被误判为过期
+				if err := paymentService.ReconcileOrders(); err != nil {
+					log.Printf("订单对账失败: %v", err)
+				}
+				if _, err := orderService.ExpireOrders(); err != nil {
+					log.Printf("处理过期订单失败: %v", err)
+				}
+			case <-rateTicker.C:
+				if err := currencyService.FetchRates(); err != nil {
+					log.Printf("更新汇率失败: %v", err)
+				}
+			case <-nodeStatusTicker.C:
+				if err := nodeService.CompactNodeStatus(); err != nil {
+					log.Printf("整理节点状态历史失败: %v", err)
+				}
+				if err := subscriptionService.CleanSubscriptionFetches(); err != nil {
+					log.Printf("清理订阅拉取记录失败: %v", err)
+				}
+			case <-nodeCheckTicker.C:
+				if err := nodeService.CheckNodesStatus(); err != nil {
+					log.Printf("检查节点状态失败: %v", err)
+				}
+			case <-probeTicker.C:
+				if err := probeService.ProbeNodes(); err != nil {
+					log.Printf("探测节点失败: %v", err)
+				}
 			}
 		}
 	}()
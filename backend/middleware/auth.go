@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"strings"
 
+	"hysteria2-panel/utils"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -19,15 +21,28 @@ func AuthRequired() gin.HandlerFunc {
 		// 移除 "Bearer " 前缀
 		token = strings.TrimPrefix(token, "Bearer ")
 
-		// TODO: 验证 JWT token
-		// userID, err := utils.ValidateToken(token)
-		// if err != nil {
-		// 	c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证令牌"})
-		// 	c.Abort()
-		// 	return
-		// }
+		claims, err := utils.ValidateToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证令牌"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("isAdmin", claims.IsAdmin)
+		c.Next()
+	}
+}
+
+// 管理员接口认证，需要在 AuthRequired 之后使用
+func AdminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("isAdmin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+			c.Abort()
+			return
+		}
 
-		// c.Set("userID", userID)
 		c.Next()
 	}
 }
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"hysteria2-panel/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 节点接口认证，令牌必须属于路径中的节点
+func NodeAuthRequired(nodeService *services.NodeService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "未提供节点令牌"})
+			c.Abort()
+			return
+		}
+
+		nodeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节点ID"})
+			c.Abort()
+			return
+		}
+
+		if err := nodeService.AuthenticateNode(uint(nodeID), token, c.ClientIP()); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("nodeID", uint(nodeID))
+		c.Next()
+	}
+}
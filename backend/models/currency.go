@@ -0,0 +1,65 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// 支持的币种
+const (
+	CurrencyCNY  = "CNY"
+	CurrencyUSD  = "USD"
+	CurrencyUSDT = "USDT"
+)
+
+// 各币种最小单位的小数位数，金额统一以最小单位（如分、美分）的整数存储
+var currencyDigits = map[string]int{
+	CurrencyCNY:  2,
+	CurrencyUSD:  2,
+	CurrencyUSDT: 2,
+}
+
+// 汇率表：1 单位 From 币种可兑换 Rate 单位 To 币种
+type ExchangeRate struct {
+	ID        uint    `gorm:"primarykey"`
+	From      string  `gorm:"size:10;not null;uniqueIndex:idx_rate_pair"`
+	To        string  `gorm:"size:10;not null;uniqueIndex:idx_rate_pair"`
+	Rate      float64 `gorm:"not null"`
+	Source    string  `gorm:"size:20;default:'manual'"` // 来源：manual-手动维护，fetched-自动获取
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// 是否为支持的币种
+func IsSupportedCurrency(currency string) bool {
+	_, ok := currencyDigits[currency]
+	return ok
+}
+
+// 币种的小数位数
+func CurrencyDigits(currency string) int {
+	if digits, ok := currencyDigits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// 将最小单位金额格式化为带小数的字符串，例如 1250 CNY -> "12.50"
+func FormatAmount(amount int64, currency string) string {
+	digits := CurrencyDigits(currency)
+	if digits == 0 {
+		return fmt.Sprintf("%d", amount)
+	}
+
+	scale := int64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, digits, amount%scale)
+}
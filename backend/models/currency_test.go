@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{1250, CurrencyCNY, "12.50"},
+		{5, CurrencyUSD, "0.05"},
+		{0, CurrencyUSDT, "0.00"},
+		{100000, CurrencyCNY, "1000.00"},
+		{-1250, CurrencyCNY, "-12.50"},
+		{-5, CurrencyUSD, "-0.05"},
+		{1250, "EUR", "12.50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := FormatAmount(tt.amount, tt.currency); got != tt.want {
+				t.Errorf("FormatAmount(%d, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// 节点 SSH 连接信息，用于向远程节点推送配置
+type NodeSSH struct {
+	NodeID     uint   `gorm:"primarykey"`
+	Port       int    `gorm:"default:22"`
+	User       string `gorm:"size:50;default:'root'"`
+	PrivateKey string `gorm:"type:text" json:"-"` // PEM 格式私钥，不返回给前端
+	HostKey    string `gorm:"type:text"`          // 首次连接时记录的主机公钥，之后连接时校验
+	ConfigPath string `gorm:"size:255;default:'/etc/hysteria/config.json'"`
+	CertPath   string `gorm:"size:255;default:'/etc/hysteria/server.crt'"`
+	KeyPath    string `gorm:"size:255;default:'/etc/hysteria/server.key'"`
+	Service    string `gorm:"size:100;default:'hysteria-server'"` // systemd 服务名
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// 部署状态
+const (
+	DeploymentRunning    = 0 // 执行中
+	DeploymentSucceeded  = 1 // 成功
+	DeploymentFailed     = 2 // 失败，未改动节点上的配置
+	DeploymentRolledBack = 3 // 服务启动失败，已回滚到之前的配置
+)
+
+// 配置部署记录
+type Deployment struct {
+	ID         uint       `gorm:"primarykey"`
+	NodeID     uint       `gorm:"not null;index"`
+	Status     int        `gorm:"default:0;not null"`
+	Log        string     `gorm:"type:text"` // 部署过程日志
+	StartedAt  time.Time  // 开始时间
+	FinishedAt *time.Time // 结束时间，执行中为空
+	CreatedAt  time.Time
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+type Invoice struct {
+	ID            uint      `gorm:"primarykey"`
+	InvoiceNo     string    `gorm:"size:50;uniqueIndex"` // 发票编号
+	Sequence      int       `gorm:"uniqueIndex"`         // 连续序号
+	OrderID       uint      `gorm:"uniqueIndex"`         // 关联订单
+	OrderNo       string    `gorm:"size:50;index"`       // 订单号
+	UserID        uint      `gorm:"not null;index"`
+	SellerName    string    `gorm:"size:100"`  // 销售方名称
+	SellerAddress string    `gorm:"size:255"`  // 销售方地址
+	SellerTaxID   string    `gorm:"size:50"`   // 销售方税号
+	SellerEmail   string    `gorm:"size:100"`  // 销售方联系邮箱
+	BuyerName     string    `gorm:"size:100"`  // 购买方名称
+	BuyerEmail    string    `gorm:"size:100"`  // 购买方邮箱
+	Items         string    `gorm:"type:text"` // 明细项（JSON）
+	Currency      string    `gorm:"size:10"`   // 币种
+	Subtotal      int64     // 不含税金额（最小货币单位）
+	TaxRate       float64   // 税率（百分比）
+	TaxAmount     int64     // 税额（最小货币单位）
+	Total         int64     // 价税合计（最小货币单位）
+	HTMLPath      string    `gorm:"size:255"` // HTML文件路径
+	PDFPath       string    `gorm:"size:255"` // PDF文件路径
+	IssuedAt      time.Time // 开具时间
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// 发票明细项
+type InvoiceItem struct {
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"` // 最小货币单位
+	Amount    int64  `json:"amount"`     // 最小货币单位
+}
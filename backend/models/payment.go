@@ -8,6 +8,8 @@ type PaymentProvider interface {
 	QueryPayment(orderNo string) (bool, error)
 	// 验证支付回调
 	VerifyCallback(params map[string]string) (string, bool)
+	// 支持的币种
+	SupportedCurrencies() []string
 }
 
 // 支付宝配置
@@ -5,17 +5,31 @@ import (
 )
 
 type Plan struct {
-	ID           uint    `gorm:"primarykey"`
-	Name         string  `gorm:"size:50;not null"`
-	Price        float64 `gorm:"not null"`           // 价格
-	Duration     int     `gorm:"not null"`           // 有效期（天）
-	TrafficLimit int64   `gorm:"not null"`           // 流量限制（字节）
-	SpeedLimit   int     `gorm:"default:0"`          // 速度限制（Mbps，0表示不限制）
-	DeviceLimit  int     `gorm:"default:0"`          // 设备限制（0表示不限制）
-	Status       int     `gorm:"default:1;not null"` // 状态：0-禁用，1-启用
-	Description  string  `gorm:"type:text"`          // 套餐描述
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID              uint   `gorm:"primarykey"`
+	Name            string `gorm:"size:50;not null"`
+	Price           int64  `gorm:"not null"`              // 基准价格（最小货币单位）
+	Currency        string `gorm:"size:10;default:'CNY'"` // 基准价格币种
+	Duration        int    `gorm:"not null"`              // 有效期（天）
+	TrafficLimit    int64  `gorm:"not null"`              // 流量限制（字节）
+	SpeedLimit      int    `gorm:"default:0"`             // 速度限制（Mbps，0表示不限制），未设置上下行带宽时使用
+	BandwidthUp     string `gorm:"size:20"`               // 用户上行带宽上限，带单位，如 "50 mbps"
+	BandwidthDown   string `gorm:"size:20"`               // 用户下行带宽上限，带单位，如 "200 mbps"
+	DeviceLimit     int    `gorm:"default:0"`             // 设备限制（0表示不限制）
+	Status          int    `gorm:"default:1;not null"`    // 状态：0-禁用，1-启用
+	Description     string `gorm:"type:text"`             // 套餐描述
+	RoutingTemplate string `gorm:"size:50"`               // 分流规则模板名称，为空使用默认模板
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Prices          []PlanPrice `gorm:"foreignKey:PlanID"`          // 其他币种的固定价格
+	NodeGroups      []NodeGroup `gorm:"many2many:plan_node_groups"` // 套餐可使用的节点分组
+}
+
+// 套餐在指定币种下的固定价格，未设置的币种按汇率换算
+type PlanPrice struct {
+	ID       uint   `gorm:"primarykey"`
+	PlanID   uint   `gorm:"not null;uniqueIndex:idx_plan_currency"`
+	Currency string `gorm:"size:10;not null;uniqueIndex:idx_plan_currency"`
+	Amount   int64  `gorm:"not null"` // 价格（最小货币单位）
 }
 
 type Subscription struct {
@@ -34,11 +48,14 @@ type Order struct {
 	ID            uint      `gorm:"primarykey"`
 	UserID        uint      `gorm:"not null;index"`
 	PlanID        uint      `gorm:"not null;index"`
-	OrderNo       string    `gorm:"size:50;uniqueIndex"` // 订单号
-	Amount        float64   `gorm:"not null"`            // 订单金额
-	PaymentMethod string    `gorm:"size:20"`             // 支付方式
-	PaymentStatus int       `gorm:"default:0"`           // 支付状态：0-未支付，1-已支付，2-已取消
+	OrderNo       string    `gorm:"size:50;uniqueIndex"`   // 订单号
+	Amount        int64     `gorm:"not null"`              // 订单金额（最小货币单位）
+	Currency      string    `gorm:"size:10;default:'CNY'"` // 订单币种
+	ExchangeRate  float64   `gorm:"default:1"`             // 下单时套餐基准币种到订单币种的汇率
+	PaymentMethod string    `gorm:"size:20"`               // 支付方式
+	PaymentStatus int       `gorm:"default:0;index"`       // 支付状态：0-未支付，1-已支付，2-已取消，3-已过期
 	PayAt         time.Time // 支付时间
+	ExpireAt      time.Time // 未支付订单的过期时间，升级前创建的订单为 NULL
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
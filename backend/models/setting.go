@@ -14,12 +14,18 @@ type Setting struct {
 
 // 系统设置的键名常量
 const (
-	SettingKeyTLS           = "tls_config"     // TLS配置
-	SettingKeyEmailSMTP     = "email_smtp"     // 邮件服务配置
-	SettingKeyDefaultQuota  = "default_quota"  // 默认流量配额
-	SettingKeyDefaultExpire = "default_expire" // 默认过期时间
-	SettingKeyAnnouncement  = "announcement"   // 系统公告
-	SettingKeyMaintenance   = "maintenance"    // 维护模式
+	SettingKeyTLS           = "tls_config"       // TLS配置
+	SettingKeyEmailSMTP     = "email_smtp"       // 邮件服务配置
+	SettingKeyDefaultQuota  = "default_quota"    // 默认流量配额
+	SettingKeyDefaultExpire = "default_expire"   // 默认过期时间
+	SettingKeyAnnouncement  = "announcement"     // 系统公告
+	SettingKeyMaintenance   = "maintenance"      // 维护模式
+	SettingKeyOrder         = "order_config"     // 订单配置
+	SettingKeyInvoice       = "invoice_config"   // 发票配置
+	SettingKeyCurrency      = "currency_config"  // 币种与汇率配置
+	SettingKeyAlert         = "alert_config"     // 告警配置
+	SettingKeyRouting       = "routing_config"   // 分流规则模板
+	SettingKeyProvision     = "provision_config" // 用户配置自动分配
 )
 
 // TLS配置结构
@@ -40,3 +46,69 @@ type SMTPConfig struct {
 	Password string `json:"password"`
 	From     string `json:"from"`
 }
+
+// 订单配置
+type OrderConfig struct {
+	ExpireMinutes int `json:"expire_minutes"` // 未支付订单过期时间（分钟）
+}
+
+// 用户配置自动分配。注册或开通套餐时自动生成认证密码，
+// 单用户独立端口模式下同时从端口池中分配未使用的端口
+type ProvisionConfig struct {
+	PerPort   bool `json:"per_port"`   // 是否为每个用户分配独立端口
+	PortStart int  `json:"port_start"` // 端口池起始端口
+	PortEnd   int  `json:"port_end"`   // 端口池结束端口（包含）
+}
+
+// 发票配置
+type InvoiceConfig struct {
+	Enabled       bool    `json:"enabled"`        // 是否在支付成功后自动开具发票
+	Prefix        string  `json:"prefix"`         // 发票编号前缀
+	SellerName    string  `json:"seller_name"`    // 销售方名称
+	SellerAddress string  `json:"seller_address"` // 销售方地址
+	SellerTaxID   string  `json:"seller_tax_id"`  // 销售方税号
+	SellerEmail   string  `json:"seller_email"`   // 销售方联系邮箱
+	TaxRate       float64 `json:"tax_rate"`       // 税率（百分比，订单金额视为含税价）
+	FontPath      string  `json:"font_path"`      // PDF使用的TTF字体路径，未设置时不生成PDF
+}
+
+// 币种与汇率配置
+type CurrencyConfig struct {
+	DefaultCurrency string `json:"default_currency"` // 下单未指定币种时使用的币种
+	AutoFetch       bool   `json:"auto_fetch"`       // 是否定期自动获取汇率
+	RateAPI         string `json:"rate_api"`         // 汇率接口地址，{base} 会被替换为基准币种
+}
+
+// 告警配置
+type AlertConfig struct {
+	Emails     []string `json:"emails"`      // 接收告警的管理员邮箱
+	WebhookURL string   `json:"webhook_url"` // 告警Webhook地址，以JSON格式POST
+}
+
+// 分流规则模板配置
+type RoutingConfig struct {
+	DefaultTemplate string            `json:"default_template"` // 用户和套餐都未指定时使用的模板，为空表示不分流
+	Templates       []RoutingTemplate `json:"templates"`
+}
+
+// 分流规则模板，生成订阅时转换为各客户端的规则格式
+type RoutingTemplate struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Groups      []RoutingGroup `json:"groups"` // 节点分组（代理组）
+	Rules       []RoutingRule  `json:"rules"`  // 按顺序匹配的规则
+}
+
+// 代理组，按名称过滤节点
+type RoutingGroup struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`   // select：手动选择，url-test：自动测速
+	Filter string `json:"filter"` // 节点名称正则，为空表示所有节点
+}
+
+// 分流规则
+type RoutingRule struct {
+	Type     string `json:"type"`     // domain、domain_suffix、domain_keyword、ip_cidr、geoip、geosite、final
+	Value    string `json:"value"`    // 匹配值，final 规则不需要
+	Outbound string `json:"outbound"` // proxy、direct、reject 或代理组名称
+}
@@ -10,16 +10,39 @@ type User struct {
 	Password     string
 	Email        string    `gorm:"unique"`
 	Traffic      int64     `gorm:"default:0"` // 已使用流量
+	Upload       int64     `gorm:"default:0"` // 已使用流量中的上传部分
 	TrafficLimit int64     `gorm:"default:0"` // 流量限制，0表示不限制
 	ExpireAt     time.Time // 账户过期时间
+	IsAdmin      bool      `gorm:"default:false"` // 是否为管理员
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
 
 type UserConfig struct {
-	UserID    uint `gorm:"primarykey"`
-	Port      int  `gorm:"unique"`
-	Password  string
-	UpSpeed   int // 上行速度限制
-	DownSpeed int // 下行速度限制
+	UserID          uint `gorm:"primarykey"`
+	Port            *int `gorm:"unique"` // 独立端口模式下分配的端口，未分配时为空
+	Password        string
+	UpSpeed         int    // 上行带宽上限（Mbps，0表示不限制）
+	DownSpeed       int    // 下行带宽上限（Mbps，0表示不限制）
+	DeviceLimit     int    // 同时在线设备数限制，0表示不限制
+	RoutingTemplate string `gorm:"size:50"` // 分流规则模板名称，为空时使用套餐的模板
+}
+
+// 订阅链接令牌，通过 /sub/:token 免登录获取节点订阅
+type SubscriptionToken struct {
+	ID            uint      `gorm:"primarykey"`
+	UserID        uint      `gorm:"uniqueIndex;not null"`
+	Token         string    `gorm:"size:64;uniqueIndex;not null"`
+	LeakAlertedAt time.Time // 最后一次发送泄露告警的时间
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// 订阅拉取记录，用于发现订阅链接泄露
+type SubscriptionFetch struct {
+	ID        uint      `gorm:"primarykey"`
+	UserID    uint      `gorm:"not null;index:idx_sub_fetch_time"`
+	IP        string    `gorm:"size:64"`
+	UserAgent string    `gorm:"size:255"`
+	FetchedAt time.Time `gorm:"not null;index:idx_sub_fetch_time"`
 }
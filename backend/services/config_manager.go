@@ -1,19 +1,29 @@
 package services
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"hysteria2-panel/models"
-	"time"
+	"math/big"
 
+	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
 )
 
+// 端口分配冲突（并发分配到同一端口）时的重试次数
+const portAllocateRetries = 5
+
 type ConfigManagerService struct {
-	db *gorm.DB
+	db             *gorm.DB
+	settingService *SettingService
 }
 
-func NewConfigManagerService(db *gorm.DB) *ConfigManagerService {
-	return &ConfigManagerService{db: db}
+func NewConfigManagerService(db *gorm.DB, settingService *SettingService) *ConfigManagerService {
+	return &ConfigManagerService{
+		db:             db,
+		settingService: settingService,
+	}
 }
 
 // 获取用户配置
@@ -29,30 +39,140 @@ func (s *ConfigManagerService) GetUserConfig(userID uint) (*models.UserConfig, e
 // 更新用户配置
 func (s *ConfigManagerService) UpdateUserConfig(userID uint, config *models.UserConfig) error {
 	config.UserID = userID
-	config.UpdatedAt = time.Now()
+	if err := s.settingService.CheckRoutingTemplate(config.RoutingTemplate); err != nil {
+		return err
+	}
 	return s.db.Save(config).Error
 }
 
-// 生成客户端配置
-func (s *ConfigManagerService) GenerateClientConfig(userID uint) (string, error) {
-	var user models.User
-	if err := s.db.First(&user, userID).Error; err != nil {
-		return "", err
+// 为用户开通配置：不存在时创建并生成随机认证密码，独立端口模式下为未分配端口的用户分配端口。
+// 在注册或开通套餐的事务中调用，已有配置时只补充缺少的端口
+func (s *ConfigManagerService) ProvisionUserConfig(tx *gorm.DB, userID uint) error {
+	provision, err := s.settingService.GetProvisionConfig()
+	if err != nil {
+		return err
+	}
+
+	var config models.UserConfig
+	err = tx.Where("user_id = ?", userID).First(&config).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	exists := err == nil
+
+	if !exists {
+		password, err := generateAuthPassword()
+		if err != nil {
+			return err
+		}
+		config = models.UserConfig{UserID: userID, Password: password}
+		if !provision.PerPort {
+			return tx.Create(&config).Error
+		}
+	}
+	if !provision.PerPort || config.Port != nil {
+		return nil
 	}
 
-	config := map[string]interface{}{
-		"server":     "your-server.com",
-		"protocol":   "hysteria2",
-		"up_mbps":    100,
-		"down_mbps":  100,
-		"auth":       user.Auth,
-		"server_key": "your-server-key",
+	// 端口唯一索引保证不会重复分配，并发冲突时重新选择端口。
+	// 事务的快照看不到其他事务之后提交的端口，因此在事务外读取已用端口，并排除已冲突的端口
+	tried := make(map[int]bool)
+	for i := 0; i < portAllocateRetries; i++ {
+		port, err := s.freePort(provision, tried)
+		if err != nil {
+			return err
+		}
+		config.Port = &port
+
+		if exists {
+			err = tx.Model(&config).Update("port", port).Error
+		} else {
+			err = tx.Create(&config).Error
+		}
+		if err == nil {
+			return nil
+		}
+		if !isDuplicateKey(err) {
+			return err
+		}
+		tried[port] = true
 	}
+	return errors.New("端口分配失败，请稍后重试")
+}
 
-	jsonBytes, err := json.MarshalIndent(config, "", "  ")
+// 按独立端口设置为用户分配或释放端口。启用时为所有未分配端口的用户分配端口；关闭时释放所有端口，
+// 用户改为连接节点的共用端口。节点代理下次同步时按用户的端口启动或停止实例
+func (s *ConfigManagerService) SyncUserPorts() error {
+	provision, err := s.settingService.GetProvisionConfig()
 	if err != nil {
+		return err
+	}
+	if !provision.PerPort {
+		return s.db.Model(&models.UserConfig{}).Where("port IS NOT NULL").Update("port", nil).Error
+	}
+
+	var userIDs []uint
+	if err := s.db.Model(&models.UserConfig{}).Where("port IS NULL").Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if err := s.ProvisionUserConfig(s.db, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从端口池中随机选择一个未被使用且不在 exclude 中的端口
+func (s *ConfigManagerService) freePort(provision *models.ProvisionConfig, exclude map[int]bool) (int, error) {
+	var used []int
+	err := s.db.Model(&models.UserConfig{}).
+		Where("port BETWEEN ? AND ?", provision.PortStart, provision.PortEnd).
+		Pluck("port", &used).Error
+	if err != nil {
+		return 0, err
+	}
+
+	usedSet := make(map[int]bool, len(used)+len(exclude))
+	for _, port := range used {
+		usedSet[port] = true
+	}
+	for port := range exclude {
+		usedSet[port] = true
+	}
+	return pickFreePort(provision, usedSet)
+}
+
+// 从端口池中随机选择一个不在 used 中的端口
+func pickFreePort(provision *models.ProvisionConfig, used map[int]bool) (int, error) {
+	free := make([]int, 0, max(provision.PortEnd-provision.PortStart+1-len(used), 0))
+	for port := provision.PortStart; port <= provision.PortEnd; port++ {
+		if !used[port] {
+			free = append(free, port)
+		}
+	}
+	if len(free) == 0 {
+		return 0, errors.New("端口池已用尽")
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(free))))
+	if err != nil {
+		return 0, err
+	}
+	return free[n.Int64()], nil
+}
+
+// 生成用户认证密码
+func generateAuthPassword() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(buf), nil
+}
 
-	return string(jsonBytes), nil
+// 是否为唯一索引冲突（MySQL 错误 1062）
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
 }
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"hysteria2-panel/models"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestPickFreePort(t *testing.T) {
+	provision := &models.ProvisionConfig{PortStart: 30000, PortEnd: 30004}
+	used := map[int]bool{30000: true, 30002: true, 30004: true}
+
+	for i := 0; i < 50; i++ {
+		port, err := pickFreePort(provision, used)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != 30001 && port != 30003 {
+			t.Fatalf("pickFreePort() = %d, want 30001 或 30003", port)
+		}
+	}
+
+	used[30001], used[30003] = true, true
+	if _, err := pickFreePort(provision, used); err == nil {
+		t.Error("端口池用尽时应返回错误")
+	}
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"唯一键冲突", &mysql.MySQLError{Number: 1062}, true},
+		{"包装后的唯一键冲突", fmt.Errorf("分配端口: %w", &mysql.MySQLError{Number: 1062}), true},
+		{"其他数据库错误", &mysql.MySQLError{Number: 1213}, false},
+		{"非数据库错误", errors.New("端口池已用尽"), false},
+		{"无错误", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDuplicateKey(tt.err); got != tt.want {
+				t.Errorf("isDuplicateKey(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
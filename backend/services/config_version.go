@@ -0,0 +1,400 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hysteria2-panel/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 每个节点保留的配置版本数，固定版本和正在运行的版本不会被清理
+const nodeConfigRetention = 100
+
+// ConfigVersionService 记录每个节点下发的服务端配置版本。版本只包含节点级配置，不包含用户认证信息；
+// 节点代理拉取配置或 SSH 部署时渲染当前配置，节点级配置变化时保存为新版本，节点固定版本时使用固定的历史版本，
+// 两种情况下用户认证信息都按当前用户实时生成，固定版本不影响用户的开通、停用和重置密码
+type ConfigVersionService struct {
+	db          *gorm.DB
+	nodeService *NodeService
+	hy2Service  *Hysteria2Service
+}
+
+func NewConfigVersionService(db *gorm.DB, nodeService *NodeService, hy2Service *Hysteria2Service) *ConfigVersionService {
+	return &ConfigVersionService{
+		db:          db,
+		nodeService: nodeService,
+		hy2Service:  hy2Service,
+	}
+}
+
+// 获取下发给节点代理的配置，返回配置版本和指定格式的配置内容
+func (s *ConfigVersionService) GetNodeConfig(nodeID uint, format string) (*models.NodeConfigVersion, []byte, error) {
+	version, config, err := s.RenderNodeConfig(nodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := EncodeConfig(config, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	return version, data, nil
+}
+
+// 渲染节点的服务端配置：节点级配置取固定版本或最新版本，用户认证信息按当前用户生成
+func (s *ConfigVersionService) RenderNodeConfig(nodeID uint) (*models.NodeConfigVersion, *ServerConfig, error) {
+	node, err := s.nodeService.GetNode(nodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err := s.nodeService.GetNodeUsers(node.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	routing, err := s.nodeService.GetEffectiveRouting(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := s.hy2Service.GenerateNodeConfig(node, users, routing)
+	if err != nil {
+		return nil, nil, err
+	}
+	userpass := current.Auth.UserPass
+
+	latest, err := s.recordVersion(node, current)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version := latest
+	config := current
+	if node.PinnedConfigVersion > 0 && node.PinnedConfigVersion != latest.Version {
+		if version, err = s.GetVersion(nodeID, node.PinnedConfigVersion); err != nil {
+			return nil, nil, err
+		}
+		config = &ServerConfig{}
+		if err := json.Unmarshal([]byte(version.Content), config); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	config.Auth.UserPass = userpass
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return version, config, nil
+}
+
+// 渲染节点上独立端口用户的服务端实例，key 为端口。实例由渲染后的节点配置派生，
+// 与用户认证信息一样不属于配置版本
+func (s *ConfigVersionService) RenderNodeInstances(nodeID uint) (map[int]*ServerConfig, error) {
+	_, config, err := s.RenderNodeConfig(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.nodeService.GetNodeUsers(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return nodeInstances(config, users)
+}
+
+// 保存节点级配置（不含用户认证信息），与最新版本内容不同时保存为新版本
+func (s *ConfigVersionService) recordVersion(node *models.Node, config *ServerConfig) (*models.NodeConfigVersion, error) {
+	nodeConfig := *config
+	nodeConfig.Auth.UserPass = nil
+	content, err := EncodeConfig(&nodeConfig, "json")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	var version models.NodeConfigVersion
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("node_id = ?", node.ID).Order("version DESC").First(&version).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && version.Hash == hash {
+			return nil
+		}
+
+		version = models.NodeConfigVersion{
+			NodeID:  node.ID,
+			Version: version.Version + 1,
+			Hash:    hash,
+			Content: string(content),
+		}
+		if err := tx.Create(&version).Error; err != nil {
+			return err
+		}
+		return s.cleanVersions(tx, node)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// 清理超出保留数量的旧版本
+func (s *ConfigVersionService) cleanVersions(tx *gorm.DB, node *models.Node) error {
+	var cutoff models.NodeConfigVersion
+	err := tx.Where("node_id = ?", node.ID).
+		Order("version DESC").
+		Offset(nodeConfigRetention).
+		First(&cutoff).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Where("node_id = ? AND version <= ? AND version NOT IN ?", node.ID, cutoff.Version,
+		[]int{node.PinnedConfigVersion, node.RunningConfigVersion}).
+		Delete(&models.NodeConfigVersion{}).Error
+}
+
+// 获取节点的配置版本列表，不包含配置内容
+func (s *ConfigVersionService) GetVersions(nodeID uint, limit int) ([]models.NodeConfigVersion, error) {
+	var versions []models.NodeConfigVersion
+	err := s.db.Omit("content").
+		Where("node_id = ?", nodeID).
+		Order("version DESC").
+		Limit(limit).
+		Find(&versions).Error
+	return versions, err
+}
+
+// 获取节点的指定配置版本
+func (s *ConfigVersionService) GetVersion(nodeID uint, version int) (*models.NodeConfigVersion, error) {
+	var v models.NodeConfigVersion
+	if err := s.db.Where("node_id = ? AND version = ?", nodeID, version).First(&v).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("配置版本 %d 不存在", version)
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// 比较节点的两个配置版本，返回统一格式的差异
+func (s *ConfigVersionService) DiffVersions(nodeID uint, from, to int) (string, error) {
+	a, err := s.GetVersion(nodeID, from)
+	if err != nil {
+		return "", err
+	}
+	b, err := s.GetVersion(nodeID, to)
+	if err != nil {
+		return "", err
+	}
+
+	return unifiedDiff(fmt.Sprintf("v%d", from), fmt.Sprintf("v%d", to),
+		strings.Split(a.Content, "\n"), strings.Split(b.Content, "\n")), nil
+}
+
+// 固定节点下发的配置版本，version 为0时恢复下发最新配置
+func (s *ConfigVersionService) PinVersion(nodeID uint, version int) error {
+	if _, err := s.nodeService.GetNode(nodeID); err != nil {
+		return err
+	}
+	if version > 0 {
+		if _, err := s.GetVersion(nodeID, version); err != nil {
+			return err
+		}
+	}
+	return s.db.Model(&models.Node{}).Where("id = ?", nodeID).Update("pinned_config_version", version).Error
+}
+
+// 将节点回滚到当前下发版本的上一个版本，并固定该版本
+func (s *ConfigVersionService) Rollback(nodeID uint) (int, error) {
+	node, err := s.nodeService.GetNode(nodeID)
+	if err != nil {
+		return 0, err
+	}
+
+	current := node.PinnedConfigVersion
+	if current == 0 {
+		var latest models.NodeConfigVersion
+		if err := s.db.Where("node_id = ?", nodeID).Order("version DESC").First(&latest).Error; err != nil {
+			return 0, errors.New("节点还没有配置版本")
+		}
+		current = latest.Version
+	}
+
+	var previous models.NodeConfigVersion
+	err = s.db.Omit("content").
+		Where("node_id = ? AND version < ?", nodeID, current).
+		Order("version DESC").
+		First(&previous).Error
+	if err != nil {
+		return 0, errors.New("没有可回滚的历史版本")
+	}
+
+	if err := s.db.Model(node).Update("pinned_config_version", previous.Version).Error; err != nil {
+		return 0, err
+	}
+	return previous.Version, nil
+}
+
+// 记录节点正在运行的配置版本，由节点代理应用配置后或 SSH 部署成功后上报。
+// 版本不存在（例如已被清理）时记为未知
+func (s *ConfigVersionService) ReportRunning(nodeID uint, version int) (int, error) {
+	if version > 0 {
+		if _, err := s.GetVersion(nodeID, version); err != nil {
+			version = 0
+		}
+	}
+
+	if err := s.db.Model(&models.Node{}).Where("id = ?", nodeID).Update("running_config_version", version).Error; err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// 差异中每个变化块前后保留的上下文行数
+const diffContext = 3
+
+// 生成统一格式（unified diff）的行差异
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// 从第一个变化向前取上下文，向后合并间隔不超过两倍上下文的变化
+		start := i
+		for start > 0 && i-start < diffContext && ops[start-1].kind == ' ' {
+			start--
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				end += min(diffContext, next-end)
+				break
+			}
+			end = next
+		}
+
+		aStart, bStart := ops[start].aLine, ops[start].bLine
+		var aCount, bCount int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart+1, aCount, bStart+1, bCount)
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.String()
+}
+
+type diffOp struct {
+	kind  byte // ' ' 相同，'-' 删除，'+' 新增
+	text  string
+	aLine int // 该行之前在 a 中的行数
+	bLine int // 该行之前在 b 中的行数
+}
+
+// 基于最长公共子序列的行差异。先去掉相同的首尾行，中间部分过大时整体视为替换，
+// 避免用户很多的节点配置占用过多内存
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	ai, bi := 0, 0
+	emit := func(kind byte, text string) {
+		ops = append(ops, diffOp{kind: kind, text: text, aLine: ai, bLine: bi})
+		if kind != '+' {
+			ai++
+		}
+		if kind != '-' {
+			bi++
+		}
+	}
+
+	for _, line := range a[:prefix] {
+		emit(' ', line)
+	}
+
+	if len(midA)*len(midB) > 4_000_000 {
+		for _, line := range midA {
+			emit('-', line)
+		}
+		for _, line := range midB {
+			emit('+', line)
+		}
+	} else {
+		// lcs[i][j] 为 midA[i:] 和 midB[j:] 的最长公共子序列长度
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				emit(' ', midA[i])
+				i++
+				j++
+			case i < len(midA) && (j == len(midB) || lcs[i+1][j] >= lcs[i][j+1]):
+				emit('-', midA[i])
+				i++
+			default:
+				emit('+', midB[j])
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		emit(' ', line)
+	}
+	return ops
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "内容相同",
+			a:    "a\nb\nc",
+			b:    "a\nb\nc",
+			want: "--- v1\n+++ v2\n",
+		},
+		{
+			name: "修改一行",
+			a:    "a\nb\nc",
+			b:    "a\nx\nc",
+			want: "--- v1\n+++ v2\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "末尾新增",
+			a:    "a\nb",
+			b:    "a\nb\nc",
+			want: "--- v1\n+++ v2\n@@ -1,2 +1,3 @@\n a\n b\n+c\n",
+		},
+		{
+			name: "开头删除",
+			a:    "a\nb\nc",
+			b:    "b\nc",
+			want: "--- v1\n+++ v2\n@@ -1,3 +1,2 @@\n-a\n b\n c\n",
+		},
+		{
+			name: "上下文只保留3行",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9",
+			b:    "1\n2\n3\n4\nx\n6\n7\n8\n9",
+			want: "--- v1\n+++ v2\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n",
+		},
+		{
+			name: "相距较远的变化分为两块",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			b:    "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny",
+			want: "--- v1\n+++ v2\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		{
+			name: "相距较近的变化合并为一块",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8",
+			b:    "x\n2\n3\n4\n5\n6\n7\ny",
+			want: "--- v1\n+++ v2\n@@ -1,8 +1,8 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n",
+		},
+		{
+			name: "中间插入保留公共行",
+			a:    "{\n\"a\": 1,\n\"b\": 2\n}",
+			b:    "{\n\"a\": 1,\n\"c\": 3,\n\"b\": 2\n}",
+			want: "--- v1\n+++ v2\n@@ -1,4 +1,5 @@\n {\n \"a\": 1,\n+\"c\": 3,\n \"b\": 2\n }\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("v1", "v2", strings.Split(tt.a, "\n"), strings.Split(tt.b, "\n"))
+			if got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// 差异中的删除行和上下文行应还原出旧内容，新增行和上下文行应还原出新内容
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+	}{
+		{"空", nil, nil},
+		{"全部新增", nil, []string{"a", "b"}},
+		{"全部删除", []string{"a", "b"}, nil},
+		{"交错修改", []string{"a", "b", "c", "d", "e"}, []string{"x", "b", "y", "d", "z"}},
+		{"重复行", []string{"a", "a", "b", "a"}, []string{"a", "b", "a", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotA, gotB []string
+			for _, op := range diffLines(tt.a, tt.b) {
+				if op.kind != '+' {
+					gotA = append(gotA, op.text)
+				}
+				if op.kind != '-' {
+					gotB = append(gotB, op.text)
+				}
+			}
+			if strings.Join(gotA, "\n") != strings.Join(tt.a, "\n") {
+				t.Errorf("旧内容 = %q, want %q", gotA, tt.a)
+			}
+			if strings.Join(gotB, "\n") != strings.Join(tt.b, "\n") {
+				t.Errorf("新内容 = %q, want %q", gotB, tt.b)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hysteria2-panel/models"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CurrencyService struct {
+	db             *gorm.DB
+	settingService *SettingService
+	client         *http.Client
+}
+
+func NewCurrencyService(db *gorm.DB, settingService *SettingService) *CurrencyService {
+	return &CurrencyService{
+		db:             db,
+		settingService: settingService,
+		client:         &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// 获取汇率列表
+func (s *CurrencyService) GetRates() ([]models.ExchangeRate, error) {
+	var rates []models.ExchangeRate
+	err := s.db.Order("`from`, `to`").Find(&rates).Error
+	return rates, err
+}
+
+// 手动设置汇率，手动维护的汇率不会被自动获取覆盖
+func (s *CurrencyService) SetRate(from, to string, rate float64) error {
+	return s.saveRate(from, to, rate, "manual", true)
+}
+
+// 删除汇率
+func (s *CurrencyService) DeleteRate(from, to string) error {
+	result := s.db.Where("`from` = ? AND `to` = ?", from, to).Delete(&models.ExchangeRate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("汇率不存在")
+	}
+	return nil
+}
+
+// 获取两个币种之间的汇率，未直接维护时尝试使用反向汇率
+func (s *CurrencyService) GetRate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	var rate models.ExchangeRate
+	err := s.db.Where("`from` = ? AND `to` = ?", from, to).First(&rate).Error
+	if err == nil {
+		return rate.Rate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	err = s.db.Where("`from` = ? AND `to` = ?", to, from).First(&rate).Error
+	if err == nil && rate.Rate > 0 {
+		return 1 / rate.Rate, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("缺少汇率: %s -> %s", from, to)
+}
+
+// 将最小单位金额从一种币种换算为另一种币种，返回换算后的金额和使用的汇率
+func (s *CurrencyService) Convert(amount int64, from, to string) (int64, float64, error) {
+	rate, err := s.GetRate(from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	return convertAmount(amount, rate, from, to), rate, nil
+}
+
+// 按汇率换算最小单位金额，两种币种的小数位数可以不同，结果四舍五入到目标币种的最小单位
+func convertAmount(amount int64, rate float64, from, to string) int64 {
+	value := float64(amount) / math.Pow10(models.CurrencyDigits(from)) * rate
+	return int64(math.Round(value * math.Pow10(models.CurrencyDigits(to))))
+}
+
+// 从汇率接口获取默认币种到其他支持币种的汇率
+func (s *CurrencyService) FetchRates() error {
+	config, err := s.settingService.GetCurrencyConfig()
+	if err != nil {
+		return err
+	}
+	if !config.AutoFetch || config.RateAPI == "" {
+		return nil
+	}
+
+	base := config.DefaultCurrency
+	url := strings.ReplaceAll(config.RateAPI, "{base}", base)
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("获取汇率失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("获取汇率失败: HTTP %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("解析汇率失败: %v", err)
+	}
+
+	// USDT 与美元锚定，汇率接口通常不提供，按美元汇率处理
+	if _, ok := result.Rates[models.CurrencyUSDT]; !ok {
+		if usd, ok := result.Rates[models.CurrencyUSD]; ok {
+			result.Rates[models.CurrencyUSDT] = usd
+		}
+	}
+
+	for currency, rate := range result.Rates {
+		if currency == base || !models.IsSupportedCurrency(currency) || rate <= 0 {
+			continue
+		}
+		if err := s.saveRate(base, currency, rate, "fetched", false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 保存汇率，overwriteManual 为 false 时跳过手动维护的汇率
+func (s *CurrencyService) saveRate(from, to string, rate float64, source string, overwriteManual bool) error {
+	if !models.IsSupportedCurrency(from) || !models.IsSupportedCurrency(to) {
+		return errors.New("不支持的币种")
+	}
+	if from == to {
+		return errors.New("币种不能相同")
+	}
+	if rate <= 0 {
+		return errors.New("汇率必须大于0")
+	}
+
+	var existing models.ExchangeRate
+	err := s.db.Where("`from` = ? AND `to` = ?", from, to).First(&existing).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return s.db.Create(&models.ExchangeRate{From: from, To: to, Rate: rate, Source: source}).Error
+	}
+
+	if existing.Source == "manual" && !overwriteManual {
+		return nil
+	}
+
+	return s.db.Model(&existing).Updates(map[string]interface{}{
+		"rate":   rate,
+		"source": source,
+	}).Error
+}
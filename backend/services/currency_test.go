@@ -0,0 +1,27 @@
+package services
+
+import "testing"
+
+func TestConvertAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		rate     float64
+		from, to string
+		want     int64
+	}{
+		{"同币种", 9900, 1, "CNY", "CNY", 9900},
+		{"人民币换算美元", 9900, 0.1389, "CNY", "USD", 1375},
+		{"美元换算人民币", 1999, 7.2, "USD", "CNY", 14393},
+		{"四舍五入到最小单位", 1, 0.5, "USD", "CNY", 1},
+		{"零金额", 0, 7.2, "USD", "CNY", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertAmount(tt.amount, tt.rate, tt.from, tt.to); got != tt.want {
+				t.Errorf("convertAmount(%d, %v, %s, %s) = %d, want %d", tt.amount, tt.rate, tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hysteria2-panel/models"
+	"log"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// 部署参数
+const (
+	deployDialTimeout = 10 * time.Second
+	deployTimeout     = 5 * time.Minute // 超过该时间仍处于执行中的部署视为已中断
+	deployStartWait   = 3 * time.Second // 重启服务后等待服务稳定的时间
+)
+
+// DeployService 通过 SSH 将渲染好的服务端配置和证书推送到远程节点，重启 systemd 服务，
+// 服务未能正常启动时恢复之前的配置。每次部署的过程记录在部署日志中
+type DeployService struct {
+	db                   *gorm.DB
+	nodeService          *NodeService
+	configVersionService *ConfigVersionService
+	certPath             string
+	keyPath              string
+}
+
+func NewDeployService(db *gorm.DB, nodeService *NodeService, configVersionService *ConfigVersionService, certPath, keyPath string) *DeployService {
+	return &DeployService{
+		db:                   db,
+		nodeService:          nodeService,
+		configVersionService: configVersionService,
+		certPath:             certPath,
+		keyPath:              keyPath,
+	}
+}
+
+// 获取节点 SSH 连接信息，未设置时返回默认值
+func (s *DeployService) GetNodeSSH(nodeID uint) (*models.NodeSSH, error) {
+	if _, err := s.nodeService.GetNode(nodeID); err != nil {
+		return nil, err
+	}
+
+	sshConfig := models.NodeSSH{
+		NodeID:     nodeID,
+		Port:       22,
+		User:       "root",
+		ConfigPath: NodeConfigPath,
+		CertPath:   NodeCertPath,
+		KeyPath:    NodeKeyPath,
+		Service:    "hysteria-server",
+	}
+	err := s.db.Where("node_id = ?", nodeID).First(&sshConfig).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &sshConfig, nil
+}
+
+// 设置节点 SSH 连接信息。私钥为空时保留原私钥；resetHostKey 为 true 时清除已记录的主机公钥，
+// 用于节点重装系统后重新信任新的主机公钥
+func (s *DeployService) SetNodeSSH(nodeID uint, sshConfig *models.NodeSSH, resetHostKey bool) error {
+	existing, err := s.GetNodeSSH(nodeID)
+	if err != nil {
+		return err
+	}
+
+	if sshConfig.PrivateKey == "" {
+		sshConfig.PrivateKey = existing.PrivateKey
+	}
+	if sshConfig.PrivateKey == "" {
+		return errors.New("请设置 SSH 私钥")
+	}
+	if _, err := ssh.ParsePrivateKey([]byte(sshConfig.PrivateKey)); err != nil {
+		return fmt.Errorf("无效的 SSH 私钥: %v", err)
+	}
+	if sshConfig.Port < 1 || sshConfig.Port > 65535 {
+		return errors.New("无效的 SSH 端口")
+	}
+	if sshConfig.User == "" {
+		return errors.New("请设置 SSH 用户名")
+	}
+	for _, p := range []string{sshConfig.ConfigPath, sshConfig.CertPath, sshConfig.KeyPath} {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("路径必须是绝对路径: %s", p)
+		}
+	}
+	if sshConfig.Service == "" {
+		return errors.New("请设置服务名")
+	}
+
+	sshConfig.NodeID = nodeID
+	sshConfig.CreatedAt = existing.CreatedAt
+	sshConfig.HostKey = existing.HostKey
+	if resetHostKey {
+		sshConfig.HostKey = ""
+	}
+	return s.db.Save(sshConfig).Error
+}
+
+// 向设置了 SSH 连接信息的节点重新部署配置，如用户凭证变更后。未设置 SSH 的节点由节点代理同步，跳过；
+// 正在部署中的节点记录日志后跳过
+func (s *DeployService) RedeployNodes(nodeIDs []uint) {
+	if len(nodeIDs) == 0 {
+		return
+	}
+
+	var configured []uint
+	if err := s.db.Model(&models.NodeSSH{}).Where("node_id IN ?", nodeIDs).Pluck("node_id", &configured).Error; err != nil {
+		log.Printf("查询节点 SSH 连接信息失败: %v", err)
+		return
+	}
+	for _, nodeID := range configured {
+		if _, err := s.Deploy(nodeID); err != nil {
+			log.Printf("重新部署节点失败，节点ID: %d, 错误: %v", nodeID, err)
+		}
+	}
+}
+
+// 开始向节点部署配置，部署在后台执行，返回部署记录
+func (s *DeployService) Deploy(nodeID uint) (*models.Deployment, error) {
+	node, err := s.nodeService.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sshConfig models.NodeSSH
+	if err := s.db.Where("node_id = ?", nodeID).First(&sshConfig).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("节点未设置 SSH 连接信息")
+		}
+		return nil, err
+	}
+
+	var running int64
+	err = s.db.Model(&models.Deployment{}).
+		Where("node_id = ? AND status = ? AND started_at > ?", nodeID, models.DeploymentRunning, time.Now().Add(-deployTimeout)).
+		Count(&running).Error
+	if err != nil {
+		return nil, err
+	}
+	if running > 0 {
+		return nil, errors.New("节点正在部署中")
+	}
+
+	deployment := &models.Deployment{
+		NodeID:    nodeID,
+		Status:    models.DeploymentRunning,
+		StartedAt: time.Now(),
+	}
+	if err := s.db.Create(deployment).Error; err != nil {
+		return nil, err
+	}
+
+	go s.run(deployment, node, &sshConfig)
+
+	return deployment, nil
+}
+
+// 获取节点的部署记录，不包含日志
+func (s *DeployService) GetDeployments(nodeID uint, limit int) ([]models.Deployment, error) {
+	var deployments []models.Deployment
+	err := s.db.Omit("log").
+		Where("node_id = ?", nodeID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&deployments).Error
+	return deployments, err
+}
+
+// 获取部署记录详情
+func (s *DeployService) GetDeployment(id uint) (*models.Deployment, error) {
+	var deployment models.Deployment
+	if err := s.db.First(&deployment, id).Error; err != nil {
+		return nil, errors.New("部署记录不存在")
+	}
+	return &deployment, nil
+}
+
+// 部署过程日志
+type deployLog struct {
+	buf bytes.Buffer
+}
+
+func (l *deployLog) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&l.buf, "[%s] ", time.Now().Format("15:04:05"))
+	fmt.Fprintf(&l.buf, format, args...)
+	l.buf.WriteByte('\n')
+}
+
+// 执行部署并保存结果
+func (s *DeployService) run(deployment *models.Deployment, node *models.Node, sshConfig *models.NodeSSH) {
+	logs := &deployLog{}
+	status := s.deploy(logs, node, sshConfig)
+
+	now := time.Now()
+	err := s.db.Model(deployment).Updates(map[string]interface{}{
+		"status":      status,
+		"log":         logs.buf.String(),
+		"finished_at": &now,
+	}).Error
+	if err != nil {
+		log.Printf("保存部署记录失败，节点ID: %d, 错误: %v", node.ID, err)
+	}
+}
+
+func (s *DeployService) deploy(logs *deployLog, node *models.Node, sshConfig *models.NodeSSH) int {
+	logs.printf("渲染节点 %s 的服务端配置", node.Name)
+	version, configData, err := s.renderConfig(node, sshConfig)
+	if err != nil {
+		logs.printf("生成配置失败: %v", err)
+		return models.DeploymentFailed
+	}
+	logs.printf("配置版本 v%d", version.Version)
+	cert, err := os.ReadFile(s.certPath)
+	if err != nil {
+		logs.printf("读取证书失败: %v", err)
+		return models.DeploymentFailed
+	}
+	key, err := os.ReadFile(s.keyPath)
+	if err != nil {
+		logs.printf("读取证书私钥失败: %v", err)
+		return models.DeploymentFailed
+	}
+
+	logs.printf("连接 %s@%s:%d", sshConfig.User, node.Host, sshConfig.Port)
+	client, err := s.dial(node, sshConfig)
+	if err != nil {
+		logs.printf("SSH 连接失败: %v", err)
+		return models.DeploymentFailed
+	}
+	defer client.Close()
+
+	if _, err := runRemote(logs, client, "command -v hysteria", nil); err != nil {
+		logs.printf("节点未安装 hysteria")
+		return models.DeploymentFailed
+	}
+
+	// Hysteria2 没有单独的配置检查命令，配置在面板渲染时已按服务端规则校验；
+	// 先上传到临时文件并校验内容，全部成功后再替换，避免节点上留下不完整的配置
+	files := []struct {
+		path string
+		data []byte
+	}{
+		{sshConfig.ConfigPath, configData},
+		{sshConfig.CertPath, cert},
+		{sshConfig.KeyPath, key},
+	}
+	for _, f := range files {
+		logs.printf("上传 %s", f.path)
+		if err := uploadFile(logs, client, f.path+".new", f.data); err != nil {
+			logs.printf("上传失败: %v", err)
+			runRemote(logs, client, "rm -f "+shellQuote(f.path+".new"), nil)
+			return models.DeploymentFailed
+		}
+	}
+
+	var backup, install, restore []string
+	for _, f := range files {
+		p := shellQuote(f.path)
+		backup = append(backup, fmt.Sprintf("if [ -f %s ]; then cp -a %s %s; else rm -f %s; fi", p, p, shellQuote(f.path+".bak"), shellQuote(f.path+".bak")))
+		// 沿用原文件的属主和权限，服务可能以非 root 用户运行
+		install = append(install, fmt.Sprintf("{ if [ -f %s ]; then chown --reference=%s %s; chmod --reference=%s %s; fi; mv -f %s %s; }",
+			p, p, shellQuote(f.path+".new"), p, shellQuote(f.path+".new"), shellQuote(f.path+".new"), p))
+		restore = append(restore, fmt.Sprintf("if [ -f %s ]; then mv -f %s %s; fi", shellQuote(f.path+".bak"), shellQuote(f.path+".bak"), p))
+	}
+
+	logs.printf("备份当前配置")
+	if _, err := runRemote(logs, client, strings.Join(backup, " && "), nil); err != nil {
+		return models.DeploymentFailed
+	}
+	if _, err := runRemote(logs, client, strings.Join(install, " && "), nil); err != nil {
+		runRemote(logs, client, strings.Join(restore, "; "), nil)
+		return models.DeploymentFailed
+	}
+
+	service := shellQuote(sshConfig.Service)
+	logs.printf("重启服务 %s", sshConfig.Service)
+	if s.restart(logs, client, service) {
+		// 用户实例与共用端口的服务相互独立，部署失败时不回滚共用端口的配置
+		if err := s.deployInstances(logs, client, node, sshConfig); err != nil {
+			logs.printf("部署用户实例失败: %v", err)
+		}
+		logs.printf("部署成功")
+		if _, err := s.configVersionService.ReportRunning(node.ID, version.Version); err != nil {
+			logs.printf("记录运行配置版本失败: %v", err)
+		}
+		return models.DeploymentSucceeded
+	}
+
+	logs.printf("服务未能正常启动，回滚到之前的配置")
+	runRemote(logs, client, "journalctl -u "+service+" -n 20 --no-pager", nil)
+	if _, err := runRemote(logs, client, strings.Join(restore, "; "), nil); err != nil {
+		logs.printf("恢复配置失败，请手动检查节点")
+		return models.DeploymentFailed
+	}
+	if !s.restart(logs, client, service) {
+		logs.printf("回滚后服务仍未能启动，请手动检查节点")
+	}
+	return models.DeploymentRolledBack
+}
+
+// 部署独立端口用户的实例：配置上传到配置文件所在目录的 instances/<端口>.json，写入 systemd 模板服务后
+// 重启各实例，停止并删除面板不再下发的实例。实例与共用端口的服务使用相同的证书
+func (s *DeployService) deployInstances(logs *deployLog, client *ssh.Client, node *models.Node, sshConfig *models.NodeSSH) error {
+	instances, err := s.configVersionService.RenderNodeInstances(node.ID)
+	if err != nil {
+		return err
+	}
+	configDir := path.Dir(sshConfig.ConfigPath)
+	dir := path.Join(configDir, NodeInstanceDir)
+
+	if len(instances) > 0 {
+		logs.printf("部署 %d 个用户实例", len(instances))
+		unitPath := "/etc/systemd/system/" + NodeInstanceService + "@.service"
+		if err := uploadFile(logs, client, unitPath, []byte(nodeInstanceUnit(configDir))); err != nil {
+			return err
+		}
+		if _, err := runRemote(logs, client, "chmod 644 "+shellQuote(unitPath)+" && systemctl daemon-reload", nil); err != nil {
+			return err
+		}
+	}
+
+	ports := make([]int, 0, len(instances))
+	for port := range instances {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	keep := make(map[string]bool, len(ports))
+	var failed []string
+	for _, port := range ports {
+		name := strconv.Itoa(port)
+		keep[name+".json"] = true
+
+		config := instances[port]
+		config.TLS = &ServerTLS{Cert: sshConfig.CertPath, Key: sshConfig.KeyPath}
+		data, err := EncodeConfig(config, "json")
+		if err != nil {
+			return err
+		}
+		if err := uploadFile(logs, client, path.Join(dir, name+".json"), data); err != nil {
+			failed = append(failed, name)
+			continue
+		}
+		unit := shellQuote(NodeInstanceService + "@" + name)
+		if _, err := runRemote(logs, client, "systemctl enable "+unit, nil); err != nil || !s.restart(logs, client, unit) {
+			failed = append(failed, name)
+		}
+	}
+
+	output, _ := runRemote(logs, client, "ls -1 "+shellQuote(dir)+" 2>/dev/null || true", nil)
+	for _, file := range strings.Fields(output) {
+		if !strings.HasSuffix(file, ".json") || keep[file] {
+			continue
+		}
+		unit := shellQuote(NodeInstanceService + "@" + strings.TrimSuffix(file, ".json"))
+		logs.printf("停止用户实例 %s", file)
+		runRemote(logs, client, "systemctl disable --now "+unit+"; rm -f "+shellQuote(path.Join(dir, file)), nil)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("端口 %s 的实例未能正常启动", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// 重启服务并检查服务是否保持运行
+func (s *DeployService) restart(logs *deployLog, client *ssh.Client, service string) bool {
+	if _, err := runRemote(logs, client, "systemctl restart "+service, nil); err != nil {
+		return false
+	}
+	time.Sleep(deployStartWait)
+	_, err := runRemote(logs, client, "systemctl is-active --quiet "+service, nil)
+	return err == nil
+}
+
+// 渲染部署到节点的配置，证书路径替换为节点上的路径
+func (s *DeployService) renderConfig(node *models.Node, sshConfig *models.NodeSSH) (*models.NodeConfigVersion, []byte, error) {
+	version, config, err := s.configVersionService.RenderNodeConfig(node.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.TLS = &ServerTLS{Cert: sshConfig.CertPath, Key: sshConfig.KeyPath}
+
+	// 配置格式与节点代理一致，按配置文件扩展名选择
+	format := "json"
+	if ext := path.Ext(sshConfig.ConfigPath); ext == ".yaml" || ext == ".yml" {
+		format = "yaml"
+	}
+	data, err := EncodeConfig(config, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	return version, data, nil
+}
+
+// 建立 SSH 连接。首次连接时记录主机公钥，之后主机公钥变化时拒绝连接
+func (s *DeployService) dial(node *models.Node, sshConfig *models.NodeSSH) (*ssh.Client, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(sshConfig.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		if sshConfig.HostKey == "" {
+			sshConfig.HostKey = authorized
+			return s.db.Model(sshConfig).Update("host_key", authorized).Error
+		}
+		if sshConfig.HostKey != authorized {
+			return fmt.Errorf("主机公钥与记录不一致（%s），如节点已重装请重置主机公钥", ssh.FingerprintSHA256(key))
+		}
+		return nil
+	}
+
+	return ssh.Dial("tcp", net.JoinHostPort(node.Host, strconv.Itoa(sshConfig.Port)), &ssh.ClientConfig{
+		User:            sshConfig.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         deployDialTimeout,
+	})
+}
+
+// 在节点上执行命令，输出写入部署日志
+func runRemote(logs *deployLog, client *ssh.Client, cmd string, stdin []byte) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		logs.printf("创建会话失败: %v", err)
+		return "", err
+	}
+	defer session.Close()
+
+	if stdin != nil {
+		session.Stdin = bytes.NewReader(stdin)
+	}
+	var output bytes.Buffer
+	session.Stdout = &output
+	session.Stderr = &output
+
+	logs.printf("$ %s", cmd)
+	err = session.Run(cmd)
+	if out := strings.TrimSpace(output.String()); out != "" {
+		logs.printf("%s", out)
+	}
+	if err != nil {
+		logs.printf("命令执行失败: %v", err)
+	}
+	return output.String(), err
+}
+
+// 通过标准输入上传文件，并用 sha256 校验上传内容
+func uploadFile(logs *deployLog, client *ssh.Client, remotePath string, data []byte) error {
+	dir := shellQuote(path.Dir(remotePath))
+	p := shellQuote(remotePath)
+	if _, err := runRemote(logs, client, fmt.Sprintf("mkdir -p %s && umask 077 && cat > %s", dir, p), data); err != nil {
+		return err
+	}
+
+	output, err := runRemote(logs, client, "sha256sum "+p, nil)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if fields := strings.Fields(output); len(fields) == 0 || fields[0] != hex.EncodeToString(sum[:]) {
+		return errors.New("文件校验失败")
+	}
+	return nil
+}
+
+// 对 shell 参数加单引号
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -1,18 +1,22 @@
 package services
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"hysteria2-panel/models"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// Hysteria2Service 根据用户和节点数据生成 Hysteria2 服务端与客户端配置
 type Hysteria2Service struct {
-	configDir   string
-	certPath    string
-	keyPath     string
-	defaultAuth AuthConfig
+	configDir string
+	certPath  string
+	keyPath   string
 }
 
 func NewHysteria2Service(configDir, certPath, keyPath string) *Hysteria2Service {
@@ -20,97 +24,447 @@ func NewHysteria2Service(configDir, certPath, keyPath string) *Hysteria2Service
 		configDir: configDir,
 		certPath:  certPath,
 		keyPath:   keyPath,
-		defaultAuth: AuthConfig{
-			Type: "password",
-		},
 	}
 }
 
-// 生成服务器配置
+// 读取面板的 TLS 证书和私钥，由节点代理拉取后写入节点的证书路径
+func (s *Hysteria2Service) NodeCertificate() (cert, key []byte, err error) {
+	if cert, err = os.ReadFile(s.certPath); err != nil {
+		return nil, nil, fmt.Errorf("读取证书失败: %v", err)
+	}
+	if key, err = os.ReadFile(s.keyPath); err != nil {
+		return nil, nil, fmt.Errorf("读取证书私钥失败: %v", err)
+	}
+	return cert, key, nil
+}
+
+// 生成单用户独立端口的服务端配置并写入配置目录
 func (s *Hysteria2Service) GenerateServerConfig(userConfig *models.UserConfig) error {
-	config := &Hysteria2Config{
-		Listen: fmt.Sprintf(":%d", userConfig.Port),
-		TLS: TLSConfig{
+	if userConfig.Port == nil {
+		return errors.New("用户未分配端口")
+	}
+
+	config := &ServerConfig{
+		Listen: fmt.Sprintf(":%d", *userConfig.Port),
+		TLS: &ServerTLS{
 			Cert: s.certPath,
 			Key:  s.keyPath,
 		},
-		Auth: AuthConfig{
-			Type:     s.defaultAuth.Type,
+		Auth: ServerAuth{
+			Type:     "password",
 			Password: userConfig.Password,
 		},
-		Bandwidth: BWConfig{
-			Up:   userConfig.UpSpeed,
-			Down: userConfig.DownSpeed,
-		},
+		Bandwidth: serverBandwidth(userConfig.DownSpeed, userConfig.UpSpeed),
 	}
-
-	// 创建配置目录
-	if err := os.MkdirAll(s.configDir, 0755); err != nil {
+	if err := config.Validate(); err != nil {
 		return err
 	}
 
-	// 生成配置文件
-	configPath := filepath.Join(s.configDir, fmt.Sprintf("config_%d.json", userConfig.UserID))
-	configData, err := json.MarshalIndent(config, "", "    ")
+	configData, err := EncodeConfig(config, "yaml")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(configPath, configData, 0644)
+	// 创建配置目录
+	if err := os.MkdirAll(s.configDir, 0755); err != nil {
+		return err
+	}
+
+	configPath := filepath.Join(s.configDir, fmt.Sprintf("config_%d.yaml", userConfig.UserID))
+	return os.WriteFile(configPath, configData, 0600)
+}
+
+// 节点流量统计接口的监听地址，只供本机的节点代理访问
+const NodeStatsListen = "127.0.0.1:25413"
+
+// 节点上的配置和证书路径，安装脚本、节点代理和 SSH 部署使用相同的路径
+const (
+	NodeConfigPath = "/etc/hysteria/config.json"
+	NodeCertPath   = "/etc/hysteria/server.crt"
+	NodeKeyPath    = "/etc/hysteria/server.key"
+)
+
+// 独立端口模式下每个用户的服务端实例：配置写入配置文件所在目录下的 instances/<端口>.json，
+// 由 systemd 模板服务 hysteria-instance@<端口> 运行
+const (
+	NodeInstanceDir     = "instances"
+	NodeInstanceService = "hysteria-instance"
+)
+
+// 运行用户实例的 systemd 模板服务，configDir 为节点上配置文件所在的目录
+func nodeInstanceUnit(configDir string) string {
+	return fmt.Sprintf(`[Unit]
+Description=Hysteria2 Server Instance on Port %%i
+After=network.target
+
+[Service]
+Type=simple
+User=root
+ExecStart=/usr/local/bin/hysteria server --config %s/%%i.json
+Restart=on-failure
+RestartSec=5
+LimitNOFILE=1048576
+
+[Install]
+WantedBy=multi-user.target
+`, path.Join(configDir, NodeInstanceDir))
 }
 
-// 生成客户端配置
-func (s *Hysteria2Service) GenerateClientConfig(domain string, userConfig *models.UserConfig) (*ClientConfig, error) {
-	return &ClientConfig{
-		Server: fmt.Sprintf("%s:%d", domain, userConfig.Port),
-		Auth:   userConfig.Password,
-		TLS: ClientTLSConfig{
-			SNI:       domain,
-			Insecure:  false,
-			Pinned:    "",
-			CA:        "",
-			SkipVerif: false,
+// 实例的流量统计接口监听在 127.0.0.2 的用户端口上，与主实例的 127.0.0.1:25413 区分，
+// 端口池包含 25413 时也不会冲突
+const nodeInstanceStatsHost = "127.0.0.2"
+
+// 生成节点服务端配置，节点上的可用用户通过 userpass 方式认证。分配了独立端口的用户
+// 只能连接自己端口上的实例，不加入共用端口的认证。routing 为节点生效的分流配置（ACL 和出站），可以为空
+func (s *Hysteria2Service) GenerateNodeConfig(node *models.Node, users []NodeUser, routing *models.NodeRouting) (*ServerConfig, error) {
+	userpass := make(map[string]string, len(users))
+	for _, user := range users {
+		if user.Port != nil {
+			continue
+		}
+		userpass[strconv.FormatUint(uint64(user.UserID), 10)] = user.Password
+	}
+
+	config := &ServerConfig{
+		Listen: fmt.Sprintf(":%d", node.Port),
+		TLS: &ServerTLS{
+			Cert: NodeCertPath,
+			Key:  NodeKeyPath,
 		},
-		Bandwidth: BWConfig{
-			Up:   userConfig.UpSpeed,
-			Down: userConfig.DownSpeed,
+		Obfs:                  nodeObfs(node),
+		QUIC:                  nodeQUIC(&node.QUIC, true),
+		Bandwidth:             nodeBandwidth(node),
+		IgnoreClientBandwidth: node.IgnoreClientBandwidth,
+		Auth: ServerAuth{
+			Type:     "userpass",
+			UserPass: userpass,
 		},
-		FastOpen:  true,
-		Lazy:      false,
-		Socks5:    ClientSocks5Config{Listen: "127.0.0.1:1080"},
-		HTTP:      ClientHTTPConfig{Listen: "127.0.0.1:8080"},
-		Transport: "",
-		Obfs:      "",
-		ObfsParam: "",
-	}, nil
-}
-
-type ClientConfig struct {
-	Server    string             `json:"server"`
-	Auth      string             `json:"auth"`
-	TLS       ClientTLSConfig    `json:"tls"`
-	Bandwidth BWConfig           `json:"bandwidth"`
-	FastOpen  bool               `json:"fastOpen"`
-	Lazy      bool               `json:"lazy"`
-	Socks5    ClientSocks5Config `json:"socks5"`
-	HTTP      ClientHTTPConfig   `json:"http"`
-	Transport string             `json:"transport,omitempty"`
-	Obfs      string             `json:"obfs,omitempty"`
-	ObfsParam string             `json:"obfsParam,omitempty"`
-}
-
-type ClientTLSConfig struct {
-	SNI       string `json:"sni"`
-	Insecure  bool   `json:"insecure"`
-	Pinned    string `json:"pinned,omitempty"`
-	CA        string `json:"ca,omitempty"`
-	SkipVerif bool   `json:"skipVerif,omitempty"`
-}
-
-type ClientSocks5Config struct {
-	Listen string `json:"listen"`
-}
-
-type ClientHTTPConfig struct {
-	Listen string `json:"listen"`
+		Masquerade: nodeMasquerade(&node.Masquerade),
+		TrafficStats: &TrafficStatsConfig{
+			Listen: NodeStatsListen,
+			Secret: node.StatsSecret,
+		},
+	}
+	if routing != nil {
+		config.ACL = routingACL(routing)
+		config.Outbounds = routingOutbounds(routing)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// 由节点的共用配置生成独立端口用户的服务端实例，key 为端口。实例只监听用户的端口、只允许该用户认证，
+// 带宽上限取用户限速与节点上限中较小的值。用户有限速时不再忽略客户端带宽，由服务端上限约束客户端声明的带宽；
+// 客户端不声明带宽时 Hysteria2 使用 BBR，不受该上限约束。
+// 伪装的 TCP 监听只由共用端口的实例提供，实例的流量统计接口监听在 nodeInstanceStatsHost 的用户端口上
+func nodeInstances(base *ServerConfig, users []NodeUser) (map[int]*ServerConfig, error) {
+	var nodeUp, nodeDown int
+	if base.Bandwidth != nil {
+		nodeUp, nodeDown = bandwidthMbps(base.Bandwidth.Up), bandwidthMbps(base.Bandwidth.Down)
+	}
+
+	instances := make(map[int]*ServerConfig)
+	for _, user := range users {
+		if user.Port == nil {
+			continue
+		}
+		port := *user.Port
+
+		config := *base
+		config.Listen = fmt.Sprintf(":%d", port)
+		config.Auth = ServerAuth{
+			Type:     "userpass",
+			UserPass: map[string]string{strconv.FormatUint(uint64(user.UserID), 10): user.Password},
+		}
+		config.Bandwidth = serverBandwidth(capMbps(user.DownSpeed, nodeUp), capMbps(user.UpSpeed, nodeDown))
+		if user.UpSpeed > 0 || user.DownSpeed > 0 {
+			config.IgnoreClientBandwidth = false
+		}
+		if base.Masquerade != nil {
+			masquerade := *base.Masquerade
+			masquerade.ListenHTTP, masquerade.ListenHTTPS, masquerade.ForceHTTPS = "", "", false
+			config.Masquerade = &masquerade
+			if masquerade.Type == "" {
+				config.Masquerade = nil
+			}
+		}
+		if base.TrafficStats != nil {
+			config.TrafficStats = &TrafficStatsConfig{
+				Listen: net.JoinHostPort(nodeInstanceStatsHost, strconv.Itoa(port)),
+				Secret: base.TrafficStats.Secret,
+			}
+		}
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("用户 %d 的实例配置无效: %v", user.UserID, err)
+		}
+		instances[port] = &config
+	}
+	return instances, nil
+}
+
+// 客户端连接的节点：分配了独立端口的用户连接自己端口上的实例，不使用端口跳跃。
+// 用户有限速时实例不忽略客户端带宽，客户端需要声明带宽才能由实例的带宽上限约束
+func userNode(node *models.Node, userConfig *models.UserConfig) *models.Node {
+	if userConfig.Port == nil {
+		return node
+	}
+	n := *node
+	n.Port = *userConfig.Port
+	n.HopPorts = ""
+	n.HopInterval = 0
+	if userConfig.UpSpeed > 0 || userConfig.DownSpeed > 0 {
+		n.IgnoreClientBandwidth = false
+	}
+	return &n
+}
+
+// 生成客户端配置，节点使用 userpass 认证，认证信息为 "用户ID:密码"
+func (s *Hysteria2Service) GenerateClientConfig(node *models.Node, userConfig *models.UserConfig) (*ClientConfig, error) {
+	node = userNode(node, userConfig)
+	config := &ClientConfig{
+		Server:    nodeServerAddr(node),
+		Auth:      fmt.Sprintf("%d:%s", userConfig.UserID, userConfig.Password),
+		TLS:       &ClientTLS{SNI: node.Host},
+		Transport: clientTransport(node),
+		Obfs:      nodeObfs(node),
+		QUIC:      nodeQUIC(&node.QUIC, false),
+		Bandwidth: clientBandwidth(ClientBandwidthMbps(node, userConfig)),
+		FastOpen:  node.FastOpen,
+		SOCKS5:    &ClientSOCKS5{Listen: "127.0.0.1:1080"},
+		HTTP:      &ClientHTTP{Listen: "127.0.0.1:8080"},
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// 生成端口跳跃所需的 iptables 规则。服务端只监听 node.Port，
+// 由 iptables 将跳跃范围内的 UDP 流量重定向到监听端口
+func (s *Hysteria2Service) GeneratePortHoppingRules(node *models.Node) ([]string, error) {
+	ranges, err := parseHopPorts(node.HopPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []string
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		for _, r := range ranges {
+			dport := strconv.Itoa(r[0])
+			if r[1] != r[0] {
+				dport = fmt.Sprintf("%d:%d", r[0], r[1])
+			}
+			rules = append(rules, fmt.Sprintf("%s -t nat -A PREROUTING -p udp --dport %s -j REDIRECT --to-ports %d",
+				cmd, dport, node.Port))
+		}
+	}
+	return rules, nil
+}
+
+// 客户端连接地址，启用端口跳跃时使用多端口形式，例如 example.com:20000-50000
+func nodeServerAddr(node *models.Node) string {
+	if node.HopPorts == "" {
+		return net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
+	}
+	// IPv6 地址使用方括号，多端口形式同样适用
+	return net.JoinHostPort(node.Host, node.HopPorts)
+}
+
+// 启用端口跳跃且设置了跳跃间隔时生成客户端传输配置
+func clientTransport(node *models.Node) *ClientTransport {
+	if node.HopPorts == "" || node.HopInterval <= 0 {
+		return nil
+	}
+	return &ClientTransport{
+		Type: "udp",
+		UDP:  &ClientUDPTransport{HopInterval: fmt.Sprintf("%ds", node.HopInterval)},
+	}
+}
+
+// 节点启用混淆时生成混淆配置，服务端和客户端使用同一密码
+func nodeObfs(node *models.Node) *ObfsConfig {
+	if node.ObfsPassword == "" {
+		return nil
+	}
+	return &ObfsConfig{
+		Type:       "salamander",
+		Salamander: &SalamanderConfig{Password: node.ObfsPassword},
+	}
+}
+
+// 生成服务端伪装配置，未设置伪装时返回 nil
+func nodeMasquerade(m *models.NodeMasquerade) *MasqueradeConfig {
+	if m.Type == "" && m.ListenHTTP == "" && m.ListenHTTPS == "" {
+		return nil
+	}
+
+	masquerade := &MasqueradeConfig{
+		Type:        m.Type,
+		ListenHTTP:  m.ListenHTTP,
+		ListenHTTPS: m.ListenHTTPS,
+		ForceHTTPS:  m.ForceHTTPS,
+	}
+	switch m.Type {
+	case "file":
+		masquerade.File = &MasqueradeFile{Dir: m.Dir}
+	case "proxy":
+		masquerade.Proxy = &MasqueradeProxy{URL: m.URL, RewriteHost: m.RewriteHost}
+	case "string":
+		masquerade.String = &MasqueradeString{Content: m.Content, StatusCode: m.StatusCode}
+	}
+	return masquerade
+}
+
+// 分流配置中的 ACL 规则，按行拆分并去掉空行，没有规则时返回 nil
+func routingACL(routing *models.NodeRouting) *ACLConfig {
+	var rules []string
+	for _, line := range strings.Split(routing.ACL, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			rules = append(rules, line)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &ACLConfig{Inline: rules}
+}
+
+// 分流配置中的出站
+func routingOutbounds(routing *models.NodeRouting) []OutboundConfig {
+	outbounds := make([]OutboundConfig, 0, len(routing.Outbounds))
+	for _, o := range routing.Outbounds {
+		outbound := OutboundConfig{Name: o.Name, Type: o.Type}
+		switch o.Type {
+		case "direct":
+			if o.Mode != "" || o.BindIPv4 != "" || o.BindIPv6 != "" || o.BindDevice != "" {
+				outbound.Direct = &DirectOutbound{
+					Mode:       o.Mode,
+					BindIPv4:   o.BindIPv4,
+					BindIPv6:   o.BindIPv6,
+					BindDevice: o.BindDevice,
+				}
+			}
+		case "socks5":
+			outbound.SOCKS5 = &SOCKS5Outbound{Addr: o.Addr, Username: o.Username, Password: o.Password}
+		case "http":
+			outbound.HTTP = &HTTPOutbound{URL: o.URL, Insecure: o.Insecure}
+		}
+		outbounds = append(outbounds, outbound)
+	}
+	return outbounds
+}
+
+// 服务端带宽配置（Mbps），up 为服务端发送即客户端下行，down 为服务端接收即客户端上行，都不限制时返回 nil
+func serverBandwidth(up, down int) *BandwidthConfig {
+	if up <= 0 && down <= 0 {
+		return nil
+	}
+	return &BandwidthConfig{
+		Up:   formatMbps(up),
+		Down: formatMbps(down),
+	}
+}
+
+// 客户端带宽配置（Mbps），客户端声明带宽时使用 Brutal 拥塞控制，都为0时返回 nil 使用 BBR
+func clientBandwidth(up, down int) *BandwidthConfig {
+	if up <= 0 && down <= 0 {
+		return nil
+	}
+	return &BandwidthConfig{
+		Up:   formatMbps(up),
+		Down: formatMbps(down),
+	}
+}
+
+// 节点服务端带宽，只使用节点设置的带宽。Hysteria2 的服务端带宽对同一端口的所有客户端相同，
+// 共用端口上不会在服务端执行用户的带宽上限：客户端配置中声明的带宽为用户上限，但修改客户端配置即可绕过。
+// 需要在服务端按用户限速时启用独立端口模式，每个用户运行在自己的实例上，实例的服务端带宽为用户上限（nodeInstances）。
+// 无论哪种模式，客户端不声明带宽时 Hysteria2 使用 BBR，服务端带宽不对其生效
+func nodeBandwidth(node *models.Node) *BandwidthConfig {
+	return serverBandwidth(bandwidthMbps(node.BandwidthUp), bandwidthMbps(node.BandwidthDown))
+}
+
+// 客户端在节点上应声明的上下行带宽（Mbps）：用户上限与节点带宽中的较小值。
+// 节点忽略客户端带宽时返回0，客户端使用 BBR
+func ClientBandwidthMbps(node *models.Node, userConfig *models.UserConfig) (up, down int) {
+	if node.IgnoreClientBandwidth {
+		return 0, 0
+	}
+	return capMbps(userConfig.UpSpeed, bandwidthMbps(node.BandwidthDown)),
+		capMbps(userConfig.DownSpeed, bandwidthMbps(node.BandwidthUp))
+}
+
+// 取两个带宽上限中较小的一个，0表示不限制
+func capMbps(a, b int) int {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// 将带单位的带宽转换为 Mbps，不足 1 Mbps 按 1 Mbps 计算，为空或无效时返回0
+func bandwidthMbps(bandwidth string) int {
+	if bandwidth == "" {
+		return 0
+	}
+	bps, err := ParseBandwidth(bandwidth)
+	if err != nil || bps == 0 {
+		return 0
+	}
+	return max(int(bps/1e6), 1)
+}
+
+// 节点 QUIC 参数，server 为 true 时生成服务端配置（不包含客户端心跳），全部为默认值时返回 nil
+func nodeQUIC(q *models.NodeQUIC, server bool) *QUICConfig {
+	config := &QUICConfig{
+		InitStreamReceiveWindow: q.InitStreamReceiveWindow,
+		MaxStreamReceiveWindow:  q.MaxStreamReceiveWindow,
+		InitConnReceiveWindow:   q.InitConnReceiveWindow,
+		MaxConnReceiveWindow:    q.MaxConnReceiveWindow,
+		DisablePathMTUDiscovery: q.DisablePathMTUDiscovery,
+	}
+	if q.MaxIdleTimeout > 0 {
+		config.MaxIdleTimeout = fmt.Sprintf("%ds", q.MaxIdleTimeout)
+	}
+	if !server && q.KeepAlivePeriod > 0 {
+		config.KeepAlivePeriod = fmt.Sprintf("%ds", q.KeepAlivePeriod)
+	}
+	if *config == (QUICConfig{}) {
+		return nil
+	}
+	return config
+}
+
+// 解析端口跳跃范围，格式为逗号分隔的端口或端口范围
+func parseHopPorts(hopPorts string) ([][2]int, error) {
+	if hopPorts == "" {
+		return nil, errors.New("节点未启用端口跳跃")
+	}
+
+	var ranges [][2]int
+	for _, part := range strings.Split(hopPorts, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("无效的端口: %s", part)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("无效的端口: %s", part)
+			}
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("无效的端口范围: %s", part)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
 }
@@ -0,0 +1,647 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 以下类型与 Hysteria2 官方服务端/客户端配置文件结构一一对应，
+// 字段名和嵌套方式参考 https://v2.hysteria.network/docs/advanced/Full-Server-Config/
+
+// 服务端配置
+type ServerConfig struct {
+	Listen                string              `json:"listen,omitempty" yaml:"listen,omitempty"`
+	TLS                   *ServerTLS          `json:"tls,omitempty" yaml:"tls,omitempty"`
+	ACME                  *ServerACME         `json:"acme,omitempty" yaml:"acme,omitempty"`
+	Obfs                  *ObfsConfig         `json:"obfs,omitempty" yaml:"obfs,omitempty"`
+	QUIC                  *QUICConfig         `json:"quic,omitempty" yaml:"quic,omitempty"`
+	Bandwidth             *BandwidthConfig    `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
+	IgnoreClientBandwidth bool                `json:"ignoreClientBandwidth,omitempty" yaml:"ignoreClientBandwidth,omitempty"`
+	Auth                  ServerAuth          `json:"auth" yaml:"auth"`
+	Resolver              *ResolverConfig     `json:"resolver,omitempty" yaml:"resolver,omitempty"`
+	ACL                   *ACLConfig          `json:"acl,omitempty" yaml:"acl,omitempty"`
+	Outbounds             []OutboundConfig    `json:"outbounds,omitempty" yaml:"outbounds,omitempty"`
+	TrafficStats          *TrafficStatsConfig `json:"trafficStats,omitempty" yaml:"trafficStats,omitempty"`
+	Masquerade            *MasqueradeConfig   `json:"masquerade,omitempty" yaml:"masquerade,omitempty"`
+}
+
+type ServerTLS struct {
+	Cert string `json:"cert" yaml:"cert"`
+	Key  string `json:"key" yaml:"key"`
+}
+
+type ServerACME struct {
+	Domains []string `json:"domains" yaml:"domains"`
+	Email   string   `json:"email,omitempty" yaml:"email,omitempty"`
+	CA      string   `json:"ca,omitempty" yaml:"ca,omitempty"` // letsencrypt 或 zerossl
+	Dir     string   `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Type    string   `json:"type,omitempty" yaml:"type,omitempty"` // http、tls 或 dns
+}
+
+// 混淆配置，服务端和客户端通用
+type ObfsConfig struct {
+	Type       string            `json:"type" yaml:"type"`
+	Salamander *SalamanderConfig `json:"salamander,omitempty" yaml:"salamander,omitempty"`
+}
+
+type SalamanderConfig struct {
+	Password string `json:"password" yaml:"password"`
+}
+
+// QUIC 参数，服务端和客户端通用，部分字段只对一端有效
+type QUICConfig struct {
+	InitStreamReceiveWindow uint64 `json:"initStreamReceiveWindow,omitempty" yaml:"initStreamReceiveWindow,omitempty"`
+	MaxStreamReceiveWindow  uint64 `json:"maxStreamReceiveWindow,omitempty" yaml:"maxStreamReceiveWindow,omitempty"`
+	InitConnReceiveWindow   uint64 `json:"initConnReceiveWindow,omitempty" yaml:"initConnReceiveWindow,omitempty"`
+	MaxConnReceiveWindow    uint64 `json:"maxConnReceiveWindow,omitempty" yaml:"maxConnReceiveWindow,omitempty"`
+	MaxIdleTimeout          string `json:"maxIdleTimeout,omitempty" yaml:"maxIdleTimeout,omitempty"`
+	KeepAlivePeriod         string `json:"keepAlivePeriod,omitempty" yaml:"keepAlivePeriod,omitempty"`       // 仅客户端
+	MaxIncomingStreams      int64  `json:"maxIncomingStreams,omitempty" yaml:"maxIncomingStreams,omitempty"` // 仅服务端
+	DisablePathMTUDiscovery bool   `json:"disablePathMTUDiscovery,omitempty" yaml:"disablePathMTUDiscovery,omitempty"`
+}
+
+// 带宽配置，取值形如 "100 mbps"
+type BandwidthConfig struct {
+	Up   string `json:"up,omitempty" yaml:"up,omitempty"`
+	Down string `json:"down,omitempty" yaml:"down,omitempty"`
+}
+
+type ServerAuth struct {
+	Type     string            `json:"type" yaml:"type"` // password、userpass、http 或 command
+	Password string            `json:"password,omitempty" yaml:"password,omitempty"`
+	UserPass map[string]string `json:"userpass,omitempty" yaml:"userpass,omitempty"`
+	HTTP     *HTTPAuth         `json:"http,omitempty" yaml:"http,omitempty"`
+	Command  string            `json:"command,omitempty" yaml:"command,omitempty"`
+}
+
+type HTTPAuth struct {
+	URL      string `json:"url" yaml:"url"`
+	Insecure bool   `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+}
+
+type ResolverConfig struct {
+	Type  string            `json:"type" yaml:"type"` // udp、tcp、tls 或 https
+	TCP   *ResolverUpstream `json:"tcp,omitempty" yaml:"tcp,omitempty"`
+	UDP   *ResolverUpstream `json:"udp,omitempty" yaml:"udp,omitempty"`
+	TLS   *ResolverUpstream `json:"tls,omitempty" yaml:"tls,omitempty"`
+	HTTPS *ResolverUpstream `json:"https,omitempty" yaml:"https,omitempty"`
+}
+
+type ResolverUpstream struct {
+	Addr     string `json:"addr" yaml:"addr"`
+	Timeout  string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	SNI      string `json:"sni,omitempty" yaml:"sni,omitempty"`
+	Insecure bool   `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+}
+
+type ACLConfig struct {
+	File              string   `json:"file,omitempty" yaml:"file,omitempty"`
+	Inline            []string `json:"inline,omitempty" yaml:"inline,omitempty"`
+	GeoIP             string   `json:"geoip,omitempty" yaml:"geoip,omitempty"`
+	GeoSite           string   `json:"geosite,omitempty" yaml:"geosite,omitempty"`
+	GeoUpdateInterval string   `json:"geoUpdateInterval,omitempty" yaml:"geoUpdateInterval,omitempty"`
+}
+
+type OutboundConfig struct {
+	Name   string          `json:"name" yaml:"name"`
+	Type   string          `json:"type" yaml:"type"` // direct、socks5 或 http
+	Direct *DirectOutbound `json:"direct,omitempty" yaml:"direct,omitempty"`
+	SOCKS5 *SOCKS5Outbound `json:"socks5,omitempty" yaml:"socks5,omitempty"`
+	HTTP   *HTTPOutbound   `json:"http,omitempty" yaml:"http,omitempty"`
+}
+
+type DirectOutbound struct {
+	Mode       string `json:"mode,omitempty" yaml:"mode,omitempty"` // auto、64、46、6、4
+	BindIPv4   string `json:"bindIPv4,omitempty" yaml:"bindIPv4,omitempty"`
+	BindIPv6   string `json:"bindIPv6,omitempty" yaml:"bindIPv6,omitempty"`
+	BindDevice string `json:"bindDevice,omitempty" yaml:"bindDevice,omitempty"`
+}
+
+type SOCKS5Outbound struct {
+	Addr     string `json:"addr" yaml:"addr"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+}
+
+type HTTPOutbound struct {
+	URL      string `json:"url" yaml:"url"`
+	Insecure bool   `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+}
+
+type TrafficStatsConfig struct {
+	Listen string `json:"listen" yaml:"listen"`
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+type MasqueradeConfig struct {
+	Type        string            `json:"type,omitempty" yaml:"type,omitempty"` // file、proxy 或 string
+	File        *MasqueradeFile   `json:"file,omitempty" yaml:"file,omitempty"`
+	Proxy       *MasqueradeProxy  `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	String      *MasqueradeString `json:"string,omitempty" yaml:"string,omitempty"`
+	ListenHTTP  string            `json:"listenHTTP,omitempty" yaml:"listenHTTP,omitempty"`
+	ListenHTTPS string            `json:"listenHTTPS,omitempty" yaml:"listenHTTPS,omitempty"`
+	ForceHTTPS  bool              `json:"forceHTTPS,omitempty" yaml:"forceHTTPS,omitempty"`
+}
+
+type MasqueradeFile struct {
+	Dir string `json:"dir" yaml:"dir"`
+}
+
+type MasqueradeProxy struct {
+	URL         string `json:"url" yaml:"url"`
+	RewriteHost bool   `json:"rewriteHost,omitempty" yaml:"rewriteHost,omitempty"`
+}
+
+type MasqueradeString struct {
+	Content    string            `json:"content" yaml:"content"`
+	Headers    map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	StatusCode int               `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+}
+
+// 客户端配置
+type ClientConfig struct {
+	Server    string           `json:"server" yaml:"server"`
+	Auth      string           `json:"auth" yaml:"auth"`
+	TLS       *ClientTLS       `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Transport *ClientTransport `json:"transport,omitempty" yaml:"transport,omitempty"`
+	Obfs      *ObfsConfig      `json:"obfs,omitempty" yaml:"obfs,omitempty"`
+	QUIC      *QUICConfig      `json:"quic,omitempty" yaml:"quic,omitempty"`
+	Bandwidth *BandwidthConfig `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
+	FastOpen  bool             `json:"fastOpen,omitempty" yaml:"fastOpen,omitempty"`
+	Lazy      bool             `json:"lazy,omitempty" yaml:"lazy,omitempty"`
+	SOCKS5    *ClientSOCKS5    `json:"socks5,omitempty" yaml:"socks5,omitempty"`
+	HTTP      *ClientHTTP      `json:"http,omitempty" yaml:"http,omitempty"`
+}
+
+type ClientTLS struct {
+	SNI       string `json:"sni,omitempty" yaml:"sni,omitempty"`
+	Insecure  bool   `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	PinSHA256 string `json:"pinSHA256,omitempty" yaml:"pinSHA256,omitempty"`
+	CA        string `json:"ca,omitempty" yaml:"ca,omitempty"`
+}
+
+type ClientTransport struct {
+	Type string              `json:"type" yaml:"type"`
+	UDP  *ClientUDPTransport `json:"udp,omitempty" yaml:"udp,omitempty"`
+}
+
+type ClientUDPTransport struct {
+	HopInterval string `json:"hopInterval,omitempty" yaml:"hopInterval,omitempty"`
+}
+
+type ClientSOCKS5 struct {
+	Listen     string `json:"listen" yaml:"listen"`
+	Username   string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password   string `json:"password,omitempty" yaml:"password,omitempty"`
+	DisableUDP bool   `json:"disableUDP,omitempty" yaml:"disableUDP,omitempty"`
+}
+
+type ClientHTTP struct {
+	Listen   string `json:"listen" yaml:"listen"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	Realm    string `json:"realm,omitempty" yaml:"realm,omitempty"`
+}
+
+// 校验服务端配置
+func (c *ServerConfig) Validate() error {
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("无效的监听地址: %s", c.Listen)
+		}
+	}
+
+	// tls 和 acme 必须且只能配置一个
+	if (c.TLS == nil) == (c.ACME == nil) {
+		return errors.New("必须且只能配置 tls 或 acme 其中之一")
+	}
+	if c.TLS != nil && (c.TLS.Cert == "" || c.TLS.Key == "") {
+		return errors.New("tls 需要指定证书和私钥路径")
+	}
+	if c.ACME != nil && len(c.ACME.Domains) == 0 {
+		return errors.New("acme 需要指定域名")
+	}
+
+	if err := c.Obfs.validate(); err != nil {
+		return err
+	}
+	if err := c.QUIC.validate(); err != nil {
+		return err
+	}
+	if err := c.Bandwidth.validate(); err != nil {
+		return err
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	if err := c.Resolver.validate(); err != nil {
+		return err
+	}
+	if err := validateACLOutbounds(c.ACL, c.Outbounds); err != nil {
+		return err
+	}
+
+	if c.TrafficStats != nil {
+		if _, _, err := net.SplitHostPort(c.TrafficStats.Listen); err != nil {
+			return fmt.Errorf("无效的流量统计监听地址: %s", c.TrafficStats.Listen)
+		}
+	}
+
+	return c.Masquerade.validate()
+}
+
+// 校验客户端配置
+func (c *ClientConfig) Validate() error {
+	if c.Server == "" {
+		return errors.New("客户端配置缺少服务器地址")
+	}
+	if c.Auth == "" {
+		return errors.New("客户端配置缺少认证信息")
+	}
+	if c.Transport != nil && c.Transport.UDP != nil && c.Transport.UDP.HopInterval != "" {
+		interval, err := time.ParseDuration(c.Transport.UDP.HopInterval)
+		if err != nil || interval < 5*time.Second {
+			return errors.New("端口跳跃间隔不能小于5秒")
+		}
+	}
+	if err := c.Obfs.validate(); err != nil {
+		return err
+	}
+	if err := c.QUIC.validate(); err != nil {
+		return err
+	}
+	return c.Bandwidth.validate()
+}
+
+func (o *ObfsConfig) validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Type != "salamander" {
+		return fmt.Errorf("不支持的混淆类型: %s", o.Type)
+	}
+	if o.Salamander == nil || o.Salamander.Password == "" {
+		return errors.New("salamander 混淆需要指定密码")
+	}
+	return nil
+}
+
+func (q *QUICConfig) validate() error {
+	if q == nil {
+		return nil
+	}
+	if q.MaxStreamReceiveWindow != 0 && q.InitStreamReceiveWindow > q.MaxStreamReceiveWindow {
+		return errors.New("initStreamReceiveWindow 不能大于 maxStreamReceiveWindow")
+	}
+	if q.MaxConnReceiveWindow != 0 && q.InitConnReceiveWindow > q.MaxConnReceiveWindow {
+		return errors.New("initConnReceiveWindow 不能大于 maxConnReceiveWindow")
+	}
+	// 取值范围与 Hysteria2 的限制一致
+	durations := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{"maxIdleTimeout", q.MaxIdleTimeout, 4 * time.Second, 120 * time.Second},
+		{"keepAlivePeriod", q.KeepAlivePeriod, 2 * time.Second, 60 * time.Second},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("无效的时间: %s", d.value)
+		}
+		if v < d.min || v > d.max {
+			return fmt.Errorf("%s 必须在 %s 到 %s 之间", d.name, d.min, d.max)
+		}
+	}
+	return nil
+}
+
+func (b *BandwidthConfig) validate() error {
+	if b == nil {
+		return nil
+	}
+	for _, v := range []string{b.Up, b.Down} {
+		if v == "" {
+			continue
+		}
+		if _, err := ParseBandwidth(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *ServerAuth) validate() error {
+	switch a.Type {
+	case "password":
+		if a.Password == "" {
+			return errors.New("password 认证需要指定密码")
+		}
+	case "userpass":
+		// 节点上可能暂时没有可用用户，允许为空
+	case "http":
+		if a.HTTP == nil || a.HTTP.URL == "" {
+			return errors.New("http 认证需要指定地址")
+		}
+	case "command":
+		if a.Command == "" {
+			return errors.New("command 认证需要指定命令")
+		}
+	default:
+		return fmt.Errorf("不支持的认证类型: %s", a.Type)
+	}
+	return nil
+}
+
+func (r *ResolverConfig) validate() error {
+	if r == nil {
+		return nil
+	}
+
+	var upstream *ResolverUpstream
+	switch r.Type {
+	case "tcp":
+		upstream = r.TCP
+	case "udp":
+		upstream = r.UDP
+	case "tls":
+		upstream = r.TLS
+	case "https":
+		upstream = r.HTTPS
+	default:
+		return fmt.Errorf("不支持的解析器类型: %s", r.Type)
+	}
+	if upstream == nil || upstream.Addr == "" {
+		return fmt.Errorf("%s 解析器需要指定地址", r.Type)
+	}
+	return nil
+}
+
+// 校验出站和引用这些出站的 ACL 规则，ACL 中的出站名称不区分大小写
+func validateACLOutbounds(acl *ACLConfig, outbounds []OutboundConfig) error {
+	names := make(map[string]bool, len(outbounds))
+	for i := range outbounds {
+		outbound := &outbounds[i]
+		if err := outbound.validate(); err != nil {
+			return err
+		}
+		name := strings.ToLower(outbound.Name)
+		if names[name] {
+			return fmt.Errorf("出站名称重复: %s", outbound.Name)
+		}
+		names[name] = true
+	}
+	return acl.validate(names)
+}
+
+func (a *ACLConfig) validate(outbounds map[string]bool) error {
+	if a == nil {
+		return nil
+	}
+	if a.File != "" && len(a.Inline) > 0 {
+		return errors.New("acl 的 file 和 inline 不能同时配置")
+	}
+	for _, line := range a.Inline {
+		if err := validateACLRule(line, outbounds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	aclRulePattern   = regexp.MustCompile(`^([A-Za-z0-9_\-]+)\s*\((.*)\)$`)
+	aclDomainPattern = regexp.MustCompile(`^[A-Za-z0-9*][A-Za-z0-9*.\-_]*$`)
+	aclGeoPattern    = regexp.MustCompile(`^[A-Za-z0-9\-_!]+(@[A-Za-z0-9\-_!]+)?$`)
+	aclPortPattern   = regexp.MustCompile(`^(tcp|udp|\*)(/(\*|\d+(-\d+)?))?$`)
+)
+
+// 校验单条 ACL 规则，格式为 outbound(address[, proto/port][, hijack_address])，
+// 参考 https://v2.hysteria.network/docs/advanced/ACL/ 。空行和 # 开头的注释行忽略。
+// 出站可以是内置的 direct、reject、default 或 outbounds 中定义的名称
+func validateACLRule(line string, outbounds map[string]bool) error {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	m := aclRulePattern.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("无效的 ACL 规则: %s", line)
+	}
+	outbound := strings.ToLower(m[1])
+	if outbound != "direct" && outbound != "reject" && outbound != "default" && !outbounds[outbound] {
+		return fmt.Errorf("ACL 规则的出站不存在: %s", line)
+	}
+
+	args := strings.Split(m[2], ",")
+	if len(args) > 3 {
+		return fmt.Errorf("ACL 规则参数过多: %s", line)
+	}
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+
+	if !validACLAddress(args[0]) {
+		return fmt.Errorf("ACL 规则的地址无效: %s", line)
+	}
+	if len(args) > 1 {
+		if !aclPortPattern.MatchString(strings.ToLower(args[1])) {
+			return fmt.Errorf("ACL 规则的协议端口无效: %s", line)
+		}
+		if _, port, ok := strings.Cut(args[1], "/"); ok && port != "*" {
+			if _, err := parseHopPorts(port); err != nil {
+				return fmt.Errorf("ACL 规则的协议端口无效: %s", line)
+			}
+		}
+	}
+	if len(args) > 2 && net.ParseIP(args[2]) == nil {
+		return fmt.Errorf("ACL 规则的劫持地址无效: %s", line)
+	}
+	return nil
+}
+
+// ACL 地址：all、IP、CIDR、域名（支持 * 通配）、suffix:域名、geoip:国家代码、geosite:分类[@属性]
+func validACLAddress(address string) bool {
+	lower := strings.ToLower(address)
+	switch {
+	case lower == "all" || lower == "*":
+		return true
+	case strings.HasPrefix(lower, "geoip:"):
+		return aclGeoPattern.MatchString(lower[len("geoip:"):])
+	case strings.HasPrefix(lower, "geosite:"):
+		return aclGeoPattern.MatchString(lower[len("geosite:"):])
+	case strings.HasPrefix(lower, "suffix:"):
+		return aclDomainPattern.MatchString(lower[len("suffix:"):])
+	case net.ParseIP(address) != nil:
+		return true
+	}
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return true
+	}
+	return aclDomainPattern.MatchString(address)
+}
+
+func (o *OutboundConfig) validate() error {
+	if o.Name == "" {
+		return errors.New("出站需要指定名称")
+	}
+
+	switch o.Type {
+	case "direct":
+		if o.Direct == nil {
+			break
+		}
+		switch o.Direct.Mode {
+		case "", "auto", "64", "46", "6", "4":
+		default:
+			return fmt.Errorf("出站 %s 的模式无效: %s", o.Name, o.Direct.Mode)
+		}
+		if o.Direct.BindIPv4 != "" && net.ParseIP(o.Direct.BindIPv4).To4() == nil {
+			return fmt.Errorf("出站 %s 绑定的IPv4地址无效: %s", o.Name, o.Direct.BindIPv4)
+		}
+		if o.Direct.BindIPv6 != "" && (net.ParseIP(o.Direct.BindIPv6) == nil || net.ParseIP(o.Direct.BindIPv6).To4() != nil) {
+			return fmt.Errorf("出站 %s 绑定的IPv6地址无效: %s", o.Name, o.Direct.BindIPv6)
+		}
+		if o.Direct.BindDevice != "" && (o.Direct.BindIPv4 != "" || o.Direct.BindIPv6 != "") {
+			return fmt.Errorf("出站 %s 不能同时绑定网卡和地址", o.Name)
+		}
+	case "socks5":
+		if o.SOCKS5 == nil {
+			return fmt.Errorf("出站 %s 缺少 socks5 配置", o.Name)
+		}
+		if _, _, err := net.SplitHostPort(o.SOCKS5.Addr); err != nil {
+			return fmt.Errorf("出站 %s 的地址无效: %s", o.Name, o.SOCKS5.Addr)
+		}
+	case "http":
+		if o.HTTP == nil {
+			return fmt.Errorf("出站 %s 缺少 http 配置", o.Name)
+		}
+		u, err := url.Parse(o.HTTP.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("出站 %s 的地址无效: %s", o.Name, o.HTTP.URL)
+		}
+	default:
+		return fmt.Errorf("不支持的出站类型: %s", o.Type)
+	}
+	return nil
+}
+
+func (m *MasqueradeConfig) validate() error {
+	if m == nil {
+		return nil
+	}
+
+	switch m.Type {
+	case "":
+	case "file":
+		if m.File == nil || m.File.Dir == "" {
+			return errors.New("文件伪装需要指定目录")
+		}
+	case "proxy":
+		if m.Proxy == nil {
+			return errors.New("反向代理伪装需要指定地址")
+		}
+		u, err := url.Parse(m.Proxy.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("无效的反向代理地址")
+		}
+	case "string":
+		if m.String == nil || m.String.Content == "" {
+			return errors.New("字符串伪装需要指定响应内容")
+		}
+		if m.String.StatusCode != 0 && (m.String.StatusCode < 100 || m.String.StatusCode > 599) {
+			return errors.New("无效的响应状态码")
+		}
+	default:
+		return fmt.Errorf("不支持的伪装类型: %s", m.Type)
+	}
+
+	for _, addr := range []string{m.ListenHTTP, m.ListenHTTPS} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("无效的监听地址: %s", addr)
+		}
+	}
+	return nil
+}
+
+var bandwidthPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([a-zA-Z]*)$`)
+
+// 带宽单位对应的比特数，与 Hysteria2 的解析规则一致
+var bandwidthUnits = map[string]float64{
+	"b":    1,
+	"bps":  1,
+	"k":    1e3,
+	"kb":   1e3,
+	"kbps": 1e3,
+	"m":    1e6,
+	"mb":   1e6,
+	"mbps": 1e6,
+	"g":    1e9,
+	"gb":   1e9,
+	"gbps": 1e9,
+	"t":    1e12,
+	"tb":   1e12,
+	"tbps": 1e12,
+}
+
+// 解析带宽字符串，如 "100 mbps"、"1g"，返回每秒比特数。必须带单位，
+// 不带单位的数字在 Hysteria2 中按 bps 解析，容易误写成极低的带宽
+func ParseBandwidth(s string) (uint64, error) {
+	matches := bandwidthPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return 0, fmt.Errorf("无效的带宽: %s", s)
+	}
+	if matches[2] == "" {
+		return 0, fmt.Errorf("带宽必须带单位，如 100 mbps: %s", s)
+	}
+
+	unit, ok := bandwidthUnits[strings.ToLower(matches[2])]
+	if !ok {
+		return 0, fmt.Errorf("无效的带宽单位: %s", s)
+	}
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("无效的带宽: %s", s)
+	}
+	return uint64(value * unit), nil
+}
+
+// 将 Mbps 转换为带宽字符串，0 表示不限制
+func formatMbps(mbps int) string {
+	if mbps <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d mbps", mbps)
+}
+
+// 按格式编码配置，format 可选 yaml 或 json（默认）
+func EncodeConfig(config interface{}, format string) ([]byte, error) {
+	switch format {
+	case "yaml", "yml":
+		return yaml.Marshal(config)
+	case "", "json":
+		return json.MarshalIndent(config, "", "    ")
+	default:
+		return nil, fmt.Errorf("不支持的配置格式: %s", format)
+	}
+}
@@ -0,0 +1,190 @@
+package services
+
+import "testing"
+
+// 通过校验的最小服务端配置
+func validServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Listen: ":443",
+		TLS:    &ServerTLS{Cert: "/etc/hysteria/server.crt", Key: "/etc/hysteria/server.key"},
+		Auth:   ServerAuth{Type: "userpass"},
+	}
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *ServerConfig)
+		wantErr bool
+	}{
+		{"最小配置", func(c *ServerConfig) {}, false},
+		{"无效监听地址", func(c *ServerConfig) { c.Listen = "443" }, true},
+		{"缺少 tls 和 acme", func(c *ServerConfig) { c.TLS = nil }, true},
+		{"同时配置 tls 和 acme", func(c *ServerConfig) { c.ACME = &ServerACME{Domains: []string{"example.com"}} }, true},
+		{"acme 缺少域名", func(c *ServerConfig) { c.TLS, c.ACME = nil, &ServerACME{} }, true},
+		{"tls 缺少私钥", func(c *ServerConfig) { c.TLS.Key = "" }, true},
+		{"salamander 混淆", func(c *ServerConfig) {
+			c.Obfs = &ObfsConfig{Type: "salamander", Salamander: &SalamanderConfig{Password: "secret"}}
+		}, false},
+		{"混淆缺少密码", func(c *ServerConfig) { c.Obfs = &ObfsConfig{Type: "salamander"} }, true},
+		{"不支持的混淆类型", func(c *ServerConfig) { c.Obfs = &ObfsConfig{Type: "xor"} }, true},
+		{"接收窗口超过最大值", func(c *ServerConfig) {
+			c.QUIC = &QUICConfig{InitStreamReceiveWindow: 2 << 20, MaxStreamReceiveWindow: 1 << 20}
+		}, true},
+		{"空闲超时超出范围", func(c *ServerConfig) { c.QUIC = &QUICConfig{MaxIdleTimeout: "2s"} }, true},
+		{"空闲超时在范围内", func(c *ServerConfig) { c.QUIC = &QUICConfig{MaxIdleTimeout: "30s"} }, false},
+		{"带宽缺少单位", func(c *ServerConfig) { c.Bandwidth = &BandwidthConfig{Up: "100"} }, true},
+		{"带宽带单位", func(c *ServerConfig) { c.Bandwidth = &BandwidthConfig{Up: "1 gbps", Down: "500 mbps"} }, false},
+		{"不支持的认证类型", func(c *ServerConfig) { c.Auth = ServerAuth{Type: "token"} }, true},
+		{"password 认证缺少密码", func(c *ServerConfig) { c.Auth = ServerAuth{Type: "password"} }, true},
+		{"解析器缺少地址", func(c *ServerConfig) { c.Resolver = &ResolverConfig{Type: "udp"} }, true},
+		{"出站名称重复", func(c *ServerConfig) {
+			c.Outbounds = []OutboundConfig{{Name: "warp", Type: "direct"}, {Name: "WARP", Type: "direct"}}
+		}, true},
+		{"ACL 引用已定义的出站", func(c *ServerConfig) {
+			c.Outbounds = []OutboundConfig{{Name: "warp", Type: "socks5", SOCKS5: &SOCKS5Outbound{Addr: "127.0.0.1:40000"}}}
+			c.ACL = &ACLConfig{Inline: []string{"warp(geosite:netflix)"}}
+		}, false},
+		{"ACL 引用未定义的出站", func(c *ServerConfig) { c.ACL = &ACLConfig{Inline: []string{"warp(all)"}} }, true},
+		{"ACL 同时配置文件和规则", func(c *ServerConfig) {
+			c.ACL = &ACLConfig{File: "acl.txt", Inline: []string{"direct(all)"}}
+		}, true},
+		{"无效的流量统计地址", func(c *ServerConfig) { c.TrafficStats = &TrafficStatsConfig{Listen: "25413"} }, true},
+		{"反向代理伪装", func(c *ServerConfig) {
+			c.Masquerade = &MasqueradeConfig{Type: "proxy", Proxy: &MasqueradeProxy{URL: "https://example.com"}}
+		}, false},
+		{"无效的反向代理地址", func(c *ServerConfig) {
+			c.Masquerade = &MasqueradeConfig{Type: "proxy", Proxy: &MasqueradeProxy{URL: "ftp://example.com"}}
+		}, true},
+		{"无效的响应状态码", func(c *ServerConfig) {
+			c.Masquerade = &MasqueradeConfig{Type: "string", String: &MasqueradeString{Content: "ok", StatusCode: 700}}
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validServerConfig()
+			tt.modify(config)
+			err := config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ClientConfig
+		wantErr bool
+	}{
+		{"最小配置", ClientConfig{Server: "example.com:443", Auth: "1:secret"}, false},
+		{"缺少服务器地址", ClientConfig{Auth: "1:secret"}, true},
+		{"缺少认证信息", ClientConfig{Server: "example.com:443"}, true},
+		{"带宽缺少单位", ClientConfig{
+			Server:    "example.com:443",
+			Auth:      "1:secret",
+			Bandwidth: &BandwidthConfig{Down: "200"},
+		}, true},
+		{"端口跳跃间隔过短", ClientConfig{
+			Server:    "example.com:20000-30000",
+			Auth:      "1:secret",
+			Transport: &ClientTransport{UDP: &ClientUDPTransport{HopInterval: "3s"}},
+		}, true},
+		{"端口跳跃间隔", ClientConfig{
+			Server:    "example.com:20000-30000",
+			Auth:      "1:secret",
+			Transport: &ClientTransport{UDP: &ClientUDPTransport{HopInterval: "30s"}},
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateACLRule(t *testing.T) {
+	outbounds := map[string]bool{"warp": true}
+
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{"", false},
+		{"# 注释", false},
+		{"direct(all)", false},
+		{"reject(geoip:cn)", false},
+		{"Direct(*)", false},
+		{"default(geosite:google@cn)", false},
+		{"warp(geosite:netflix)", false},
+		{"WARP(suffix:example.com)", false},
+		{"direct(*.example.com)", false},
+		{"direct(192.168.0.0/16)", false},
+		{"direct(2001:db8::1)", false},
+		{"reject(all, udp/443)", false},
+		{"reject(all, tcp/80-90)", false},
+		{"direct(all, */*)", false},
+		{"direct(example.com, tcp, 127.0.0.1)", false},
+		{"direct(all) # 注释", false},
+		{"proxy(all)", true},
+		{"direct all", true},
+		{"direct()", true},
+		{"direct(geoip:c n)", true},
+		{"direct(all, icmp/1)", true},
+		{"direct(all, tcp/0)", true},
+		{"direct(all, tcp/90-80)", true},
+		{"direct(all, tcp/abc)", true},
+		{"direct(example.com, tcp, localhost)", true},
+		{"direct(a, tcp, 127.0.0.1, extra)", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			err := validateACLRule(tt.rule, outbounds)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateACLRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{"100 mbps", 100e6, false},
+		{"100mbps", 100e6, false},
+		{"100 Mbps", 100e6, false},
+		{" 1g ", 1e9, false},
+		{"1.5 gbps", 1.5e9, false},
+		{"500 kbps", 500e3, false},
+		{"2 tb", 2e12, false},
+		{"800 bps", 800, false},
+		{"100", 0, true},
+		{"", 0, true},
+		{"mbps", 0, true},
+		{"-1 mbps", 0, true},
+		{"100 mibps", 0, true},
+		{"1,000 mbps", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseBandwidth(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBandwidth(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseBandwidth(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"testing"
+
+	"hysteria2-panel/models"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// 分配了独立端口的用户不加入共用端口的认证
+func TestGenerateNodeConfigSkipsPortUsers(t *testing.T) {
+	node := &models.Node{Host: "node.example.com", Port: 443, StatsSecret: "stats"}
+	users := []NodeUser{
+		{UserID: 1, Password: "shared"},
+		{UserID: 2, Password: "own", Port: intPtr(30001)},
+	}
+
+	config, err := NewHysteria2Service("", "", "").GenerateNodeConfig(node, users, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Auth.UserPass) != 1 || config.Auth.UserPass["1"] != "shared" {
+		t.Errorf("共用端口的认证用户 = %v, want 只有用户 1", config.Auth.UserPass)
+	}
+}
+
+func TestNodeInstances(t *testing.T) {
+	base := validServerConfig()
+	base.Bandwidth = &BandwidthConfig{Up: "1 gbps", Down: "100 mbps"}
+	base.IgnoreClientBandwidth = true
+	base.Masquerade = &MasqueradeConfig{
+		Type:        "proxy",
+		Proxy:       &MasqueradeProxy{URL: "https://example.com"},
+		ListenHTTP:  ":80",
+		ListenHTTPS: ":443",
+		ForceHTTPS:  true,
+	}
+	base.TrafficStats = &TrafficStatsConfig{Listen: NodeStatsListen, Secret: "stats"}
+	users := []NodeUser{
+		{UserID: 1, Password: "shared"},
+		{UserID: 2, Password: "capped", Port: intPtr(30001), UpSpeed: 200, DownSpeed: 50},
+		{UserID: 3, Password: "unlimited", Port: intPtr(30002)},
+	}
+
+	instances, err := nodeInstances(base, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || instances[30001] == nil || instances[30002] == nil {
+		t.Fatalf("实例端口 = %v, want 30001 和 30002", instances)
+	}
+
+	capped := instances[30001]
+	if capped.Listen != ":30001" {
+		t.Errorf("Listen = %q, want :30001", capped.Listen)
+	}
+	if len(capped.Auth.UserPass) != 1 || capped.Auth.UserPass["2"] != "capped" {
+		t.Errorf("实例认证用户 = %v, want 只有用户 2", capped.Auth.UserPass)
+	}
+	// 服务端上行对应用户下载，用户上行受节点下行上限约束
+	if capped.Bandwidth == nil || capped.Bandwidth.Up != "50 mbps" || capped.Bandwidth.Down != "100 mbps" {
+		t.Errorf("Bandwidth = %+v, want up 50 mbps, down 100 mbps", capped.Bandwidth)
+	}
+	if capped.IgnoreClientBandwidth {
+		t.Error("有限速的用户实例不应忽略客户端带宽")
+	}
+	if m := capped.Masquerade; m == nil || m.Type != "proxy" || m.ListenHTTP != "" || m.ListenHTTPS != "" || m.ForceHTTPS {
+		t.Errorf("Masquerade = %+v, want 保留伪装类型且不监听 TCP", m)
+	}
+	if capped.TrafficStats == nil || capped.TrafficStats.Listen != "127.0.0.2:30001" || capped.TrafficStats.Secret != "stats" {
+		t.Errorf("TrafficStats = %+v, want 127.0.0.2:30001", capped.TrafficStats)
+	}
+
+	unlimited := instances[30002]
+	if unlimited.Bandwidth == nil || unlimited.Bandwidth.Up != "1000 mbps" || unlimited.Bandwidth.Down != "100 mbps" {
+		t.Errorf("Bandwidth = %+v, want 节点上限", unlimited.Bandwidth)
+	}
+	if !unlimited.IgnoreClientBandwidth {
+		t.Error("未限速的用户实例应沿用节点的忽略客户端带宽设置")
+	}
+
+	// 实例不能修改共用端口的配置
+	if base.Listen != ":443" || base.Masquerade.ListenHTTP != ":80" || len(base.Auth.UserPass) != 0 {
+		t.Errorf("共用端口的配置被修改: %+v", base)
+	}
+}
+
+func TestGenerateClientConfigUsesUserPort(t *testing.T) {
+	node := &models.Node{
+		Host:                  "node.example.com",
+		Port:                  443,
+		HopPorts:              "20000-30000",
+		HopInterval:           30,
+		IgnoreClientBandwidth: true,
+	}
+	hy2 := NewHysteria2Service("", "", "")
+
+	shared, err := hy2.GenerateClientConfig(node, &models.UserConfig{UserID: 1, Password: "secret", UpSpeed: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared.Server != "node.example.com:20000-30000" || shared.Bandwidth != nil {
+		t.Errorf("共用端口: Server = %q, Bandwidth = %+v", shared.Server, shared.Bandwidth)
+	}
+
+	own, err := hy2.GenerateClientConfig(node, &models.UserConfig{UserID: 1, Password: "secret", Port: intPtr(30001), UpSpeed: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own.Server != "node.example.com:30001" {
+		t.Errorf("Server = %q, want node.example.com:30001", own.Server)
+	}
+	if own.Transport != nil {
+		t.Errorf("独立端口不应使用端口跳跃: %+v", own.Transport)
+	}
+	if own.Bandwidth == nil || own.Bandwidth.Up != "50 mbps" {
+		t.Errorf("Bandwidth = %+v, want 声明用户上行限速", own.Bandwidth)
+	}
+	if node.Port != 443 || node.HopPorts == "" {
+		t.Errorf("节点被修改: %+v", node)
+	}
+}
+
+func TestClientBandwidthMbps(t *testing.T) {
+	tests := []struct {
+		name             string
+		node             models.Node
+		user             models.UserConfig
+		wantUp, wantDown int
+	}{
+		{"不限速", models.Node{}, models.UserConfig{}, 0, 0},
+		{"用户限速", models.Node{}, models.UserConfig{UpSpeed: 50, DownSpeed: 200}, 50, 200},
+		{"节点带宽更小", models.Node{BandwidthUp: "100 mbps", BandwidthDown: "20 mbps"},
+			models.UserConfig{UpSpeed: 50, DownSpeed: 200}, 20, 100},
+		{"只有节点带宽", models.Node{BandwidthUp: "1 gbps"}, models.UserConfig{}, 0, 1000},
+		{"忽略客户端带宽", models.Node{IgnoreClientBandwidth: true}, models.UserConfig{UpSpeed: 50, DownSpeed: 200}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down := ClientBandwidthMbps(&tt.node, &tt.user)
+			if up != tt.wantUp || down != tt.wantDown {
+				t.Errorf("ClientBandwidthMbps() = (%d, %d), want (%d, %d)", up, down, tt.wantUp, tt.wantDown)
+			}
+		})
+	}
+}
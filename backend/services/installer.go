@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// 节点代理支持的架构
+var agentArchs = map[string]bool{
+	"amd64": true,
+	"arm64": true,
+}
+
+// InstallerService 生成节点一键安装脚本。脚本安装 Hysteria2 和节点代理，
+// 写入 systemd 服务并使用节点令牌向面板注册，之后由节点代理拉取配置
+type InstallerService struct {
+	nodeService *NodeService
+	hy2Service  *Hysteria2Service
+	agentDir    string // 节点代理二进制目录，文件名为 hy2-agent-linux-<arch>
+}
+
+func NewInstallerService(nodeService *NodeService, hy2Service *Hysteria2Service, agentDir string) *InstallerService {
+	return &InstallerService{
+		nodeService: nodeService,
+		hy2Service:  hy2Service,
+		agentDir:    agentDir,
+	}
+}
+
+// 节点安装信息
+type NodeInstall struct {
+	Command string `json:"command"` // 在节点上以 root 执行的一键安装命令
+	Script  string `json:"script"`  // 已填入面板地址和节点令牌的完整安装脚本
+}
+
+// 生成节点安装脚本和一键安装命令。令牌明文只能在生成时获取，因此会轮换节点令牌，
+// 已安装的节点代理需要使用新令牌
+func (s *InstallerService) GenerateNodeInstall(nodeID uint, panelURL string) (*NodeInstall, error) {
+	u, err := url.Parse(panelURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("无效的面板地址")
+	}
+	panelURL = strings.TrimRight(panelURL, "/")
+
+	node, err := s.nodeService.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var hopRules []string
+	if node.HopPorts != "" {
+		if hopRules, err = s.hy2Service.GeneratePortHoppingRules(node); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := s.nodeService.RotateNodeToken(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := s.RenderInstallScript(&installParams{
+		PanelURL: panelURL,
+		NodeID:   node.ID,
+		Token:    token,
+		HopRules: hopRules,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 令牌通过环境变量传给脚本，不会出现在进程参数中
+	return &NodeInstall{
+		Command: fmt.Sprintf("curl -fsSL %s | HY2_PANEL_URL=%s HY2_NODE_ID=%d HY2_NODE_TOKEN=%s bash",
+			shellQuote(panelURL+"/install/node.sh"), shellQuote(panelURL), node.ID, shellQuote(token)),
+		Script: script,
+	}, nil
+}
+
+// 渲染安装脚本，params 为空时生成从环境变量读取面板地址、节点ID和令牌的通用脚本
+func (s *InstallerService) RenderInstallScript(params *installParams) (string, error) {
+	if params == nil {
+		params = &installParams{}
+	}
+
+	var buf bytes.Buffer
+	if err := installScript.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// 获取节点代理二进制文件路径
+func (s *InstallerService) AgentBinary(arch string) (string, error) {
+	if !agentArchs[arch] {
+		return "", fmt.Errorf("不支持的架构: %s", arch)
+	}
+
+	path := filepath.Join(s.agentDir, "hy2-agent-linux-"+arch)
+	if _, err := os.Stat(path); err != nil {
+		return "", errors.New("节点代理文件不存在，请先编译 cmd/agent")
+	}
+	return path, nil
+}
+
+type installParams struct {
+	PanelURL string
+	NodeID   uint
+	Token    string
+	HopRules []string // 端口跳跃的 iptables 规则
+}
+
+var installScript = template.Must(template.New("install").Funcs(template.FuncMap{
+	"quote":        shellQuote,
+	"instanceUnit": func() string { return nodeInstanceUnit(path.Dir(NodeConfigPath)) },
+}).Parse(`#!/bin/bash
+# Hysteria2 Panel 节点安装脚本：安装 Hysteria2 和节点代理，并向面板注册
+
+set -e
+
+# 颜色定义
+RED="\033[31m"
+GREEN="\033[32m"
+YELLOW="\033[33m"
+PLAIN="\033[0m"
+
+# 检查root权限
+[[ $EUID -ne 0 ]] && echo -e "${RED}错误：请使用root用户运行此脚本${PLAIN}" && exit 1
+
+# 节点令牌通过环境变量传入，避免出现在进程参数中
+PANEL_URL=${HY2_PANEL_URL:-{{quote .PanelURL}}}
+NODE_ID=${HY2_NODE_ID:-{{if .NodeID}}{{.NodeID}}{{end}}}
+NODE_TOKEN=${HY2_NODE_TOKEN:-{{quote .Token}}}
+
+if [[ -z "${PANEL_URL}" || -z "${NODE_ID}" || -z "${NODE_TOKEN}" ]]; then
+    echo -e "${RED}用法: HY2_PANEL_URL=<面板地址> HY2_NODE_ID=<节点ID> HY2_NODE_TOKEN=<节点令牌> bash node.sh${PLAIN}"
+    exit 1
+fi
+
+case "$(uname -m)" in
+    x86_64|amd64) ARCH="amd64" ;;
+    aarch64|arm64) ARCH="arm64" ;;
+    *) echo -e "${RED}不支持的架构: $(uname -m)${PLAIN}" && exit 1 ;;
+esac
+
+echo -e "${GREEN}开始安装 Hysteria2 节点...${PLAIN}"
+
+# 1. 安装基础依赖
+if [[ -f /etc/debian_version ]]; then
+    apt update
+    apt install -y curl iptables
+else
+    yum install -y curl iptables
+fi
+
+# 2. 安装 Hysteria2
+curl -fsSL -o /usr/local/bin/hysteria "https://github.com/apernet/hysteria/releases/latest/download/hysteria-linux-${ARCH}"
+chmod +x /usr/local/bin/hysteria
+mkdir -p /etc/hysteria
+
+# 节点代理将面板生成的配置写入 /etc/hysteria/config.json，证书写入 /etc/hysteria/server.crt 和 server.key，并重启该服务
+cat > /etc/systemd/system/hysteria-server.service << EOF
+[Unit]
+Description=Hysteria2 Server Service
+After=network.target
+
+[Service]
+Type=simple
+User=root
+ExecStart=/usr/local/bin/hysteria server --config /etc/hysteria/config.json
+Restart=on-failure
+RestartSec=5
+LimitNOFILE=1048576
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+# 独立端口模式下，节点代理将每个用户的实例配置写入 /etc/hysteria/instances/<端口>.json，并启动 hysteria-instance@<端口>
+mkdir -p /etc/hysteria/instances
+cat > /etc/systemd/system/hysteria-instance@.service << 'EOF'
+{{instanceUnit}}EOF
+
+# 3. 安装节点代理
+curl -fsSL -o /usr/local/bin/hy2-agent "${PANEL_URL}/install/agent/${ARCH}"
+chmod +x /usr/local/bin/hy2-agent
+mkdir -p /etc/hy2-agent
+
+cat > /etc/hy2-agent/agent.env << EOF
+HY2_PANEL_URL=${PANEL_URL}
+HY2_NODE_ID=${NODE_ID}
+HY2_NODE_TOKEN=${NODE_TOKEN}
+EOF
+chmod 600 /etc/hy2-agent/agent.env
+
+cat > /etc/systemd/system/hy2-agent.service << EOF
+[Unit]
+Description=Hysteria2 Panel Node Agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User=root
+EnvironmentFile=/etc/hy2-agent/agent.env
+ExecStart=/usr/local/bin/hy2-agent --config /etc/hysteria/config.json --service hysteria-server
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+EOF
+{{if .HopRules}}
+# 4. 端口跳跃：将跳跃范围内的 UDP 流量重定向到监听端口，开机时重新应用
+cat > /etc/hysteria/port-hopping.sh << 'EOF'
+#!/bin/bash
+{{range .HopRules}}{{.}}
+{{end}}EOF
+chmod +x /etc/hysteria/port-hopping.sh
+
+cat > /etc/systemd/system/hysteria-port-hopping.service << EOF
+[Unit]
+Description=Hysteria2 Port Hopping Rules
+After=network.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/etc/hysteria/port-hopping.sh
+
+[Install]
+WantedBy=multi-user.target
+EOF
+{{end}}
+systemctl daemon-reload
+{{- if .HopRules}}
+systemctl enable --now hysteria-port-hopping
+{{- end}}
+systemctl enable hysteria-server
+# 节点代理启动时向面板注册，拉取配置和证书后启动 hysteria-server
+systemctl enable hy2-agent
+systemctl restart hy2-agent
+
+sleep 5
+if systemctl is-active --quiet hy2-agent; then
+    echo -e "${GREEN}安装完成！${PLAIN}"
+else
+    echo -e "${RED}节点代理启动失败，请查看日志: journalctl -u hy2-agent${PLAIN}"
+    exit 1
+fi
+echo -e "${YELLOW}==================================${PLAIN}"
+echo -e "${YELLOW}节点ID: ${NODE_ID}${PLAIN}"
+echo -e "${YELLOW}面板地址: ${PANEL_URL}${PLAIN}"
+echo -e "${YELLOW}==================================${PLAIN}"
+`))
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"hysteria2-panel/models"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type InvoiceService struct {
+	db             *gorm.DB
+	settingService *SettingService
+	mailService    *MailService
+	invoiceDir     string
+}
+
+func NewInvoiceService(db *gorm.DB, settingService *SettingService, mailService *MailService, invoiceDir string) *InvoiceService {
+	return &InvoiceService{
+		db:             db,
+		settingService: settingService,
+		mailService:    mailService,
+		invoiceDir:     invoiceDir,
+	}
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"amount": models.FormatAmount,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>发票 {{.Invoice.InvoiceNo}}</title></head>
+<body>
+	<h2>发票</h2>
+	<p>发票编号: {{.Invoice.InvoiceNo}}<br>订单号: {{.Invoice.OrderNo}}<br>开具时间: {{.IssuedAt}}</p>
+	<h3>销售方</h3>
+	<p>{{.Invoice.SellerName}}<br>{{.Invoice.SellerAddress}}<br>税号: {{.Invoice.SellerTaxID}}<br>{{.Invoice.SellerEmail}}</p>
+	<h3>购买方</h3>
+	<p>{{.Invoice.BuyerName}}<br>{{.Invoice.BuyerEmail}}</p>
+	<table border="1" cellpadding="6" cellspacing="0">
+		<tr><th>项目</th><th>数量</th><th>单价</th><th>金额</th></tr>
+		{{range .Items}}<tr><td>{{.Name}}</td><td>{{.Quantity}}</td><td>{{amount .UnitPrice $.Invoice.Currency}}</td><td>{{amount .Amount $.Invoice.Currency}}</td></tr>
+		{{end}}
+	</table>
+	<p>币种: {{.Invoice.Currency}}<br>不含税金额: {{amount .Invoice.Subtotal .Invoice.Currency}}<br>税率: {{printf "%.2f" .Invoice.TaxRate}}%<br>税额: {{amount .Invoice.TaxAmount .Invoice.Currency}}<br><strong>价税合计: {{amount .Invoice.Total .Invoice.Currency}}</strong></p>
+</body>
+</html>
+`))
+
+// 为已支付订单开具发票，已开具过则直接返回
+func (s *InvoiceService) IssueInvoice(orderNo string) (*models.Invoice, error) {
+	var order models.Order
+	if err := s.db.Where("order_no = ?", orderNo).First(&order).Error; err != nil {
+		return nil, errors.New("订单不存在")
+	}
+	if order.PaymentStatus != 1 {
+		return nil, errors.New("订单未支付")
+	}
+
+	config, err := s.settingService.GetInvoiceConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var existing models.Invoice
+	err = s.db.Where("order_id = ?", order.ID).First(&existing).Error
+	if err == nil {
+		if existing.HTMLPath == "" {
+			if err := s.renderFiles(&existing, config); err != nil {
+				return nil, err
+			}
+		}
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var user models.User
+	if err := s.db.First(&user, order.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	var plan models.Plan
+	if err := s.db.First(&plan, order.PlanID).Error; err != nil {
+		return nil, err
+	}
+
+	items := []models.InvoiceItem{{
+		Name:      fmt.Sprintf("%s（%d天）", plan.Name, plan.Duration),
+		Quantity:  1,
+		UnitPrice: order.Amount,
+		Amount:    order.Amount,
+	}}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	subtotal, taxAmount := invoiceAmounts(order.Amount, config.TaxRate)
+
+	invoice := &models.Invoice{
+		OrderID:       order.ID,
+		OrderNo:       order.OrderNo,
+		UserID:        order.UserID,
+		SellerName:    config.SellerName,
+		SellerAddress: config.SellerAddress,
+		SellerTaxID:   config.SellerTaxID,
+		SellerEmail:   config.SellerEmail,
+		BuyerName:     user.Username,
+		BuyerEmail:    user.Email,
+		Items:         string(itemsJSON),
+		Currency:      order.Currency,
+		Subtotal:      subtotal,
+		TaxRate:       config.TaxRate,
+		TaxAmount:     taxAmount,
+		Total:         order.Amount,
+		IssuedAt:      time.Now(),
+	}
+
+	// 在事务中锁定当前最大序号，保证发票编号连续且不重复。支付回调和对账可能同时为同一订单开具发票，
+	// 订单已有发票时插入不生效，改为使用已有的发票
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var sequence int
+		if err := tx.Model(&models.Invoice{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("COALESCE(MAX(sequence), 0)").
+			Scan(&sequence).Error; err != nil {
+			return err
+		}
+
+		invoice.Sequence = sequence + 1
+		invoice.InvoiceNo = fmt.Sprintf("%s%s%06d", config.Prefix, invoice.IssuedAt.Format("2006"), invoice.Sequence)
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(invoice)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			*invoice = models.Invoice{}
+			return tx.Where("order_id = ?", order.ID).First(invoice).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 文件在发票记录写入后生成，文件名为发票编号，同一发票重复生成时内容相同；
+	// 生成失败时保留发票记录，下次获取发票时重新生成
+	if invoice.HTMLPath == "" {
+		if err := s.renderFiles(invoice, config); err != nil {
+			return nil, err
+		}
+	}
+	return invoice, nil
+}
+
+// 订单金额为含税价，按税率反算不含税金额和税额（最小货币单位），两者之和等于订单金额
+func invoiceAmounts(total int64, taxRate float64) (subtotal, taxAmount int64) {
+	subtotal = int64(math.Round(float64(total) / (1 + taxRate/100)))
+	return subtotal, total - subtotal
+}
+
+// 获取订单对应的发票，不存在时在启用发票功能的情况下为已支付订单补开
+func (s *InvoiceService) GetInvoiceByOrderNo(orderNo string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := s.db.Where("order_no = ?", orderNo).First(&invoice).Error
+	if err == nil && invoice.HTMLPath != "" {
+		return &invoice, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		config, err := s.settingService.GetInvoiceConfig()
+		if err != nil {
+			return nil, err
+		}
+		if !config.Enabled {
+			return nil, errors.New("发票功能未启用")
+		}
+	}
+	return s.IssueInvoice(orderNo)
+}
+
+// 支付成功后自动开具发票并发送邮件
+func (s *InvoiceService) HandleOrderPaid(orderNo string) error {
+	config, err := s.settingService.GetInvoiceConfig()
+	if err != nil {
+		return err
+	}
+	if !config.Enabled {
+		return nil
+	}
+
+	invoice, err := s.IssueInvoice(orderNo)
+	if err != nil {
+		return err
+	}
+
+	return s.SendInvoice(invoice)
+}
+
+// 通过邮件发送发票
+func (s *InvoiceService) SendInvoice(invoice *models.Invoice) error {
+	if invoice.BuyerEmail == "" {
+		return nil
+	}
+
+	body, err := os.ReadFile(invoice.HTMLPath)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("发票 %s", invoice.InvoiceNo)
+	return s.mailService.SendMail([]string{invoice.BuyerEmail}, subject, string(body))
+}
+
+// 生成HTML和PDF文件并记录路径，用于新开具的发票和之前生成失败的发票
+func (s *InvoiceService) renderFiles(invoice *models.Invoice, config *models.InvoiceConfig) error {
+	var items []models.InvoiceItem
+	if err := json.Unmarshal([]byte(invoice.Items), &items); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.invoiceDir, 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	data := map[string]interface{}{
+		"Invoice":  invoice,
+		"Items":    items,
+		"IssuedAt": invoice.IssuedAt.Format("2006-01-02 15:04:05"),
+	}
+	if err := invoiceTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	htmlPath := filepath.Join(s.invoiceDir, invoice.InvoiceNo+".html")
+	if err := os.WriteFile(htmlPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	updates := map[string]interface{}{"html_path": htmlPath}
+
+	// PDF需要支持中文的字体，未配置字体时只生成HTML
+	if config.FontPath != "" {
+		pdfPath := filepath.Join(s.invoiceDir, invoice.InvoiceNo+".pdf")
+		if err := s.renderPDF(pdfPath, invoice, items, config.FontPath); err != nil {
+			return fmt.Errorf("生成PDF发票失败: %v", err)
+		}
+		updates["pdf_path"] = pdfPath
+		invoice.PDFPath = pdfPath
+	}
+	invoice.HTMLPath = htmlPath
+
+	return s.db.Model(invoice).Updates(updates).Error
+}
+
+// 生成PDF发票
+func (s *InvoiceService) renderPDF(path string, invoice *models.Invoice, items []models.InvoiceItem, fontPath string) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8Font("invoice", "", fontPath)
+	pdf.AddPage()
+
+	pdf.SetFont("invoice", "", 18)
+	pdf.CellFormat(0, 12, "发票", "", 1, "C", false, 0, "")
+
+	pdf.SetFont("invoice", "", 10)
+	lines := []string{
+		"发票编号: " + invoice.InvoiceNo,
+		"订单号: " + invoice.OrderNo,
+		"开具时间: " + invoice.IssuedAt.Format("2006-01-02 15:04:05"),
+		"",
+		"销售方: " + invoice.SellerName,
+		"地址: " + invoice.SellerAddress,
+		"税号: " + invoice.SellerTaxID,
+		"邮箱: " + invoice.SellerEmail,
+		"",
+		"购买方: " + invoice.BuyerName,
+		"邮箱: " + invoice.BuyerEmail,
+		"",
+	}
+	for _, line := range lines {
+		pdf.CellFormat(0, 6, line, "", 1, "L", false, 0, "")
+	}
+
+	widths := []float64{90, 20, 35, 35}
+	for i, header := range []string{"项目", "数量", "单价", "金额"} {
+		pdf.CellFormat(widths[i], 8, header, "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+	for _, item := range items {
+		pdf.CellFormat(widths[0], 8, item.Name, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 8, fmt.Sprintf("%d", item.Quantity), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[2], 8, models.FormatAmount(item.UnitPrice, invoice.Currency), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 8, models.FormatAmount(item.Amount, invoice.Currency), "1", 0, "R", false, 0, "")
+		pdf.Ln(-1)
+	}
+
+	pdf.Ln(4)
+	totals := []string{
+		"币种: " + invoice.Currency,
+		"不含税金额: " + models.FormatAmount(invoice.Subtotal, invoice.Currency),
+		fmt.Sprintf("税率: %.2f%%", invoice.TaxRate),
+		"税额: " + models.FormatAmount(invoice.TaxAmount, invoice.Currency),
+		"价税合计: " + models.FormatAmount(invoice.Total, invoice.Currency),
+	}
+	for _, line := range totals {
+		pdf.CellFormat(0, 6, line, "", 1, "R", false, 0, "")
+	}
+
+	return pdf.OutputFileAndClose(path)
+}
@@ -0,0 +1,33 @@
+package services
+
+import "testing"
+
+func TestInvoiceAmounts(t *testing.T) {
+	tests := []struct {
+		name         string
+		total        int64
+		taxRate      float64
+		wantSubtotal int64
+		wantTax      int64
+	}{
+		{"不含税", 9900, 0, 9900, 0},
+		{"6%税率", 10600, 6, 10000, 600},
+		{"13%税率", 11300, 13, 10000, 1300},
+		{"四舍五入到分", 1000, 6, 943, 57},
+		{"小数税率", 9900, 6.5, 9296, 604},
+		{"零金额", 0, 6, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subtotal, tax := invoiceAmounts(tt.total, tt.taxRate)
+			if subtotal != tt.wantSubtotal || tax != tt.wantTax {
+				t.Errorf("invoiceAmounts(%d, %v) = (%d, %d), want (%d, %d)",
+					tt.total, tt.taxRate, subtotal, tax, tt.wantSubtotal, tt.wantTax)
+			}
+			if subtotal+tax != tt.total {
+				t.Errorf("不含税金额与税额之和 %d 不等于订单金额 %d", subtotal+tax, tt.total)
+			}
+		})
+	}
+}
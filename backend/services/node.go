@@ -1,24 +1,65 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"hysteria2-panel/models"
+	"log"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// 节点状态历史保留策略
+const (
+	nodeStatusRawRetention    = 24 * time.Hour      // 原始采样保留时间
+	nodeStatusHourlyRetention = 30 * 24 * time.Hour // 小时均值保留时间
+)
+
 type NodeService struct {
-	db *gorm.DB
+	db                  *gorm.DB
+	notificationService *NotificationService
+	mutex               sync.RWMutex
+	// 节点最新状态缓存，key 为节点ID
+	latest map[uint]*models.NodeStatus
 }
 
-func NewNodeService(db *gorm.DB) *NodeService {
-	return &NodeService{db: db}
+func NewNodeService(db *gorm.DB, notificationService *NotificationService) *NodeService {
+	return &NodeService{
+		db:                  db,
+		notificationService: notificationService,
+		latest:              make(map[uint]*models.NodeStatus),
+	}
 }
 
-// 创建节点
-func (s *NodeService) CreateNode(node *models.Node) error {
-	return s.db.Create(node).Error
+// 创建节点，同时生成节点令牌（明文只在此时返回）
+func (s *NodeService) CreateNode(node *models.Node) (string, error) {
+	if err := ValidateNode(node); err != nil {
+		return "", err
+	}
+	secret, err := generateAuthPassword()
+	if err != nil {
+		return "", err
+	}
+	node.StatsSecret = secret
+
+	var token string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(node).Error; err != nil {
+			return err
+		}
+
+		var err error
+		token, err = s.saveNodeToken(tx, node.ID)
+		return err
+	})
+	return token, err
 }
 
 // 获取节点列表
@@ -28,14 +69,353 @@ func (s *NodeService) GetNodes() ([]models.Node, error) {
 	return nodes, err
 }
 
-// 更新节点状态
-func (s *NodeService) UpdateNodeStatus(nodeID uint, status *models.NodeStatus) error {
+// 获取节点
+func (s *NodeService) GetNode(nodeID uint) (*models.Node, error) {
+	var node models.Node
+	if err := s.db.First(&node, nodeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("节点不存在")
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+// 允许通过接口修改的节点字段，状态由心跳和维护模式控制，混淆密码由面板生成
+var nodeUpdatableFields = map[string]bool{
+	"name":         true,
+	"host":         true,
+	"port":         true,
+	"type":         true,
+	"group_id":     true,
+	"hop_ports":    true,
+	"hop_interval": true,
+	// 伪装配置
+	"masquerade_type":         true,
+	"masquerade_dir":          true,
+	"masquerade_url":          true,
+	"masquerade_rewrite_host": true,
+	"masquerade_content":      true,
+	"masquerade_status_code":  true,
+	"masquerade_listen_http":  true,
+	"masquerade_listen_https": true,
+	"masquerade_force_https":  true,
+	// 带宽与 QUIC 参数
+	"bandwidth_up":                    true,
+	"bandwidth_down":                  true,
+	"ignore_client_bandwidth":         true,
+	"fast_open":                       true,
+	"quic_init_stream_receive_window": true,
+	"quic_max_stream_receive_window":  true,
+	"quic_init_conn_receive_window":   true,
+	"quic_max_conn_receive_window":    true,
+	"quic_max_idle_timeout":           true,
+	"quic_keep_alive_period":          true,
+	"quic_disable_path_mtu_discovery": true,
+}
+
+// 更新节点信息
+func (s *NodeService) UpdateNode(nodeID uint, updates map[string]interface{}) error {
+	for field := range updates {
+		if !nodeUpdatableFields[field] {
+			return errors.New("不允许修改的字段: " + field)
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// 值没有变化时 MySQL 返回的影响行数为0，因此单独检查节点是否存在
+		var node models.Node
+		if err := tx.First(&node, nodeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("节点不存在")
+			}
+			return err
+		}
+		if err := tx.Model(&node).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		// 校验更新后的端口配置，不合法时回滚
+		if err := tx.First(&node, nodeID).Error; err != nil {
+			return err
+		}
+		return ValidateNode(&node)
+	})
+}
+
+// 校验节点端口、端口跳跃和伪装配置
+func ValidateNode(node *models.Node) error {
+	if node.Port < 1 || node.Port > 65535 {
+		return errors.New("无效的节点端口")
+	}
+	if node.HopInterval < 0 {
+		return errors.New("端口跳跃间隔不能为负数")
+	}
+	// Hysteria2 客户端要求跳跃间隔不小于5秒
+	if node.HopInterval > 0 && node.HopInterval < 5 {
+		return errors.New("端口跳跃间隔不能小于5秒")
+	}
+	if node.HopPorts != "" {
+		if _, err := parseHopPorts(node.HopPorts); err != nil {
+			return err
+		}
+	}
+	for _, bandwidth := range []string{node.BandwidthUp, node.BandwidthDown} {
+		if bandwidth == "" {
+			continue
+		}
+		if _, err := ParseBandwidth(bandwidth); err != nil {
+			return err
+		}
+	}
+	if node.QUIC.KeepAlivePeriod > 0 && node.QUIC.MaxIdleTimeout > 0 && node.QUIC.KeepAlivePeriod >= node.QUIC.MaxIdleTimeout {
+		return errors.New("心跳间隔必须小于空闲超时")
+	}
+	if err := nodeQUIC(&node.QUIC, false).validate(); err != nil {
+		return err
+	}
+	return nodeMasquerade(&node.Masquerade).validate()
+}
+
+// 删除节点及其凭证、状态历史和故障记录，节点代理随即无法再访问面板
+func (s *NodeService) DeleteNode(nodeID uint) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.Node{}, nodeID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("节点不存在")
+		}
+
+		if err := tx.Where("node_id = ?", nodeID).Delete(&models.NodeCredential{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id = ?", nodeID).Delete(&models.NodeStatusRecord{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id = ?", nodeID).Delete(&models.NodeRouting{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id = ?", nodeID).Delete(&models.NodeSSH{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id = ?", nodeID).Delete(&models.NodeConfigVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("node_id = ?", nodeID).Delete(&models.NodeIncident{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	delete(s.latest, nodeID)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// 设置节点维护模式。维护中的节点不再出现在订阅中，但节点代理仍可拉取用户和配置，
+// 已连接的用户不受影响，等待其自然断开
+func (s *NodeService) SetNodeMaintenance(nodeID uint, maintenance bool) error {
+	node, err := s.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	if maintenance {
+		return s.db.Model(node).Update("status", 2).Error
+	}
+	if node.Status != 2 {
+		return nil
+	}
+
+	// 退出维护时根据最近心跳恢复状态，离线的节点交给下一次心跳恢复
+	if time.Since(node.LastPing) >= 5*time.Minute {
+		return s.db.Model(node).Update("status", 0).Error
+	}
+	if err := s.db.Model(node).Update("status", 1).Error; err != nil {
+		return err
+	}
+	// 维护期间恢复心跳的节点，结束维护前的故障记录
+	return s.recoverIncident(nodeID)
+}
+
+// 启用或关闭节点的 Salamander 混淆。密码由面板生成，服务端配置和客户端配置都从节点读取，
+// 节点代理同步配置后两端自动一致；再次启用会轮换密码
+func (s *NodeService) SetNodeObfs(nodeID uint, enabled bool) (string, error) {
+	if _, err := s.GetNode(nodeID); err != nil {
+		return "", err
+	}
+
+	password := ""
+	if enabled {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		password = hex.EncodeToString(buf)
+	}
+
+	err := s.db.Model(&models.Node{}).Where("id = ?", nodeID).Update("obfs_password", password).Error
+	return password, err
+}
+
+// 节点批量操作结果
+type NodeBatchResult struct {
+	NodeID uint   `json:"node_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+// 批量操作节点，指定分组时操作分组内的所有节点，action 可选 maintenance、resume、delete，
+// 单个节点失败不影响其余节点
+func (s *NodeService) BatchNodes(nodeIDs []uint, groupID uint, action string) ([]NodeBatchResult, error) {
+	if groupID > 0 {
+		if err := s.db.Model(&models.Node{}).Where("group_id = ?", groupID).Pluck("id", &nodeIDs).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var apply func(uint) error
+	switch action {
+	case "maintenance":
+		apply = func(id uint) error { return s.SetNodeMaintenance(id, true) }
+	case "resume":
+		apply = func(id uint) error { return s.SetNodeMaintenance(id, false) }
+	case "delete":
+		apply = s.DeleteNode
+	default:
+		return nil, errors.New("不支持的操作: " + action)
+	}
+
+	results := make([]NodeBatchResult, 0, len(nodeIDs))
+	for _, id := range nodeIDs {
+		result := NodeBatchResult{NodeID: id}
+		if err := apply(id); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// 创建节点分组
+func (s *NodeService) CreateNodeGroup(group *models.NodeGroup) error {
+	return s.db.Create(group).Error
+}
+
+// 获取节点分组列表
+func (s *NodeService) GetNodeGroups() ([]models.NodeGroup, error) {
+	var groups []models.NodeGroup
+	err := s.db.Find(&groups).Error
+	return groups, err
+}
+
+// 更新节点分组
+func (s *NodeService) UpdateNodeGroup(groupID uint, updates map[string]interface{}) error {
+	result := s.db.Model(&models.NodeGroup{}).Where("id = ?", groupID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("节点分组不存在")
+	}
+	return nil
+}
+
+// 删除节点分组，分组内的节点变为未分组（对所有用户开放），套餐中的授权一并移除
+func (s *NodeService) DeleteNodeGroup(groupID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.NodeGroup{}, groupID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("节点分组不存在")
+		}
+
+		if err := tx.Model(&models.Node{}).Where("group_id = ?", groupID).Update("group_id", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ? AND node_id = 0", groupID).Delete(&models.NodeRouting{}).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM plan_node_groups WHERE node_group_id = ?", groupID).Error
+	})
+}
+
+// 用户当前生效套餐授权的节点分组
+func (s *NodeService) entitledGroups(userID uint) *gorm.DB {
+	return s.db.Table("plan_node_groups").
+		Select("plan_node_groups.node_group_id").
+		Joins("JOIN subscriptions ON subscriptions.plan_id = plan_node_groups.plan_id").
+		Where("subscriptions.user_id = ? AND subscriptions.status = 1 AND subscriptions.end_at > ?", userID, time.Now())
+}
+
+// 当前生效套餐授权了指定节点分组的用户
+func (s *NodeService) entitledUsers(groupID uint) *gorm.DB {
+	return s.db.Table("subscriptions").
+		Select("subscriptions.user_id").
+		Joins("JOIN plan_node_groups ON plan_node_groups.plan_id = subscriptions.plan_id").
+		Where("plan_node_groups.node_group_id = ? AND subscriptions.status = 1 AND subscriptions.end_at > ?", groupID, time.Now())
+}
+
+// 获取用户套餐授权的可用节点，用于生成订阅。未分组的节点对所有用户开放
+func (s *NodeService) GetUserNodes(userID uint) ([]models.Node, error) {
+	var nodes []models.Node
+	err := s.db.Where("status = ? AND (group_id = 0 OR group_id IN (?))", 1, s.entitledGroups(userID)).Find(&nodes).Error
+	return nodes, err
+}
+
+// 获取用户有权使用的节点，节点不可用或套餐未授权时返回错误
+func (s *NodeService) GetUserNode(userID, nodeID uint) (*models.Node, error) {
+	var node models.Node
+	err := s.db.Where("id = ? AND status = ? AND (group_id = 0 OR group_id IN (?))", nodeID, 1, s.entitledGroups(userID)).
+		First(&node).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("节点不可用或当前套餐无权使用该节点")
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+// 节点代理注册
+func (s *NodeService) RegisterNode(nodeID uint, version, hostname string) (*models.Node, error) {
+	var node models.Node
+	if err := s.db.First(&node, nodeID).Error; err != nil {
+		return nil, errors.New("节点不存在")
+	}
+
 	updates := map[string]interface{}{
-		"status":    1, // 在线
-		"last_ping": time.Now(),
+		"last_ping":     time.Now(),
+		"agent_version": version,
+		"hostname":      hostname,
+	}
+	// 升级前创建的节点没有流量统计密钥，在节点代理注册时补充
+	if node.StatsSecret == "" {
+		secret, err := generateAuthPassword()
+		if err != nil {
+			return nil, err
+		}
+		updates["stats_secret"] = secret
 	}
+	if err := s.db.Model(&node).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.markNodeOnline(nodeID); err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
 
-	result := s.db.Model(&models.Node{}).Where("id = ?", nodeID).Updates(updates)
+// 更新节点状态
+func (s *NodeService) UpdateNodeStatus(nodeID uint, status *models.NodeStatus) error {
+	result := s.db.Model(&models.Node{}).Where("id = ?", nodeID).Update("last_ping", time.Now())
 	if result.Error != nil {
 		return result.Error
 	}
@@ -43,32 +423,403 @@ func (s *NodeService) UpdateNodeStatus(nodeID uint, status *models.NodeStatus) e
 		return errors.New("节点不存在")
 	}
 
-	// 保存节点状态到缓存或其他存储中
-	// TODO: 实现状态存储逻辑
+	if err := s.markNodeOnline(nodeID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	record := &models.NodeStatusRecord{
+		NodeID:      nodeID,
+		CPU:         status.CPU,
+		Memory:      status.Memory,
+		Disk:        status.Disk,
+		Load:        status.Load,
+		NetworkIn:   status.NetworkIn,
+		NetworkOut:  status.NetworkOut,
+		ActiveUsers: status.ActiveUsers,
+		TotalUsers:  status.TotalUsers,
+		ReportedAt:  now,
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return err
+	}
+
+	// 以面板接收时间为准，避免节点时钟偏差
+	latest := *status
+	latest.LastReportAt = now.Unix()
+
+	s.mutex.Lock()
+	s.latest[nodeID] = &latest
+	s.mutex.Unlock()
 
 	return nil
 }
 
 // 获取节点状态
 func (s *NodeService) GetNodeStatus(nodeID uint) (*models.NodeStatus, error) {
+	s.mutex.RLock()
+	cached, ok := s.latest[nodeID]
+	s.mutex.RUnlock()
+	if ok {
+		status := *cached
+		return &status, nil
+	}
+
 	var node models.Node
 	if err := s.db.First(&node, nodeID).Error; err != nil {
 		return nil, err
 	}
 
-	// TODO: 从缓存或其他存储中获取节点状态
+	// 缓存中没有时（如面板重启后）从历史记录中读取最近一次上报
+	var record models.NodeStatusRecord
+	err := s.db.Where("node_id = ?", nodeID).Order("reported_at DESC").First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &models.NodeStatus{LastReportAt: node.LastPing.Unix()}, nil
+		}
+		return nil, err
+	}
+
 	status := &models.NodeStatus{
-		LastReportAt: node.LastPing.Unix(),
+		CPU:          record.CPU,
+		Memory:       record.Memory,
+		Disk:         record.Disk,
+		Load:         record.Load,
+		NetworkIn:    record.NetworkIn,
+		NetworkOut:   record.NetworkOut,
+		ActiveUsers:  record.ActiveUsers,
+		TotalUsers:   record.TotalUsers,
+		LastReportAt: record.ReportedAt.Unix(),
 	}
 
-	return status, nil
+	s.mutex.Lock()
+	s.latest[nodeID] = status
+	s.mutex.Unlock()
+
+	copied := *status
+	return &copied, nil
+}
+
+// 获取节点状态历史，时间范围超出原始采样保留时间时返回小时均值
+func (s *NodeService) GetNodeStatusHistory(nodeID uint, start, end time.Time) ([]models.NodeStatusRecord, error) {
+	resolution := 0
+	if time.Since(start) > nodeStatusRawRetention {
+		resolution = 3600
+	}
+
+	var records []models.NodeStatusRecord
+	err := s.db.Where("node_id = ? AND reported_at >= ? AND reported_at < ?", nodeID, start, end).
+		Where("resolution = ?", resolution).
+		Order("reported_at").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 小时均值只覆盖已降采样的时段，补上最近的原始采样
+	if resolution > 0 {
+		var recent []models.NodeStatusRecord
+		err := s.db.Where("node_id = ? AND reported_at >= ? AND reported_at < ?", nodeID, start, end).
+			Where("resolution = 0").
+			Order("reported_at").
+			Find(&recent).Error
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, recent...)
+	}
+
+	return records, nil
+}
+
+// 将过期的原始采样降采样为小时均值，并清理超出保留时间的记录
+func (s *NodeService) CompactNodeStatus() error {
+	cutoff := time.Now().Add(-nodeStatusRawRetention).Truncate(time.Hour)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO node_status_records
+			(node_id, resolution, cpu, memory, disk, `+"`load`"+`, network_in, network_out, active_users, total_users, reported_at)
+			SELECT node_id, 3600, AVG(cpu), AVG(memory), AVG(disk), AVG(`+"`load`"+`), MAX(network_in), MAX(network_out),
+				ROUND(AVG(active_users)), MAX(total_users),
+				FROM_UNIXTIME(FLOOR(UNIX_TIMESTAMP(reported_at) / 3600) * 3600) AS bucket
+			FROM node_status_records
+			WHERE resolution = 0 AND reported_at < ?
+			GROUP BY node_id, bucket`, cutoff).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("resolution = 0 AND reported_at < ?", cutoff).
+			Delete(&models.NodeStatusRecord{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("resolution = 3600 AND reported_at < ?", time.Now().Add(-nodeStatusHourlyRetention)).
+			Delete(&models.NodeStatusRecord{}).Error
+	})
 }
 
 // 检查节点在线状态
 func (s *NodeService) CheckNodesStatus() error {
 	// 将超过5分钟未更新的节点标记为离线
 	timeout := time.Now().Add(-5 * time.Minute)
-	return s.db.Model(&models.Node{}).
-		Where("last_ping < ? AND status = ?", timeout, 1).
-		Update("status", 0).Error
+
+	var nodes []models.Node
+	if err := s.db.Where("last_ping < ? AND status = ?", timeout, 1).Find(&nodes).Error; err != nil {
+		return err
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+
+		// 带状态条件更新，避免与同时到达的心跳冲突
+		result := s.db.Model(&models.Node{}).
+			Where("id = ? AND status = ?", node.ID, 1).
+			Update("status", 0)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		incident := &models.NodeIncident{
+			NodeID:    node.ID,
+			StartedAt: node.LastPing,
+		}
+		if err := s.db.Create(incident).Error; err != nil {
+			return err
+		}
+
+		go func() {
+			if err := s.notificationService.SendNodeDownAlert(node, incident); err != nil {
+				log.Printf("发送节点离线告警失败，节点ID: %d, 错误: %v", node.ID, err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// 离线节点重新上报时恢复为在线，并结束故障记录
+func (s *NodeService) markNodeOnline(nodeID uint) error {
+	result := s.db.Model(&models.Node{}).
+		Where("id = ? AND status = ?", nodeID, 0).
+		Update("status", 1)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	return s.recoverIncident(nodeID)
+}
+
+// 结束节点未恢复的故障记录并通知管理员
+func (s *NodeService) recoverIncident(nodeID uint) error {
+	var incident models.NodeIncident
+	err := s.db.Where("node_id = ? AND recovered_at IS NULL", nodeID).
+		Order("started_at DESC").
+		First(&incident).Error
+	if err != nil {
+		// 新节点首次上线没有故障记录
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	incident.RecoveredAt = &now
+	incident.Duration = int64(now.Sub(incident.StartedAt).Seconds())
+	if err := s.db.Save(&incident).Error; err != nil {
+		return err
+	}
+
+	node, err := s.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.notificationService.SendNodeRecoveredAlert(node, &incident); err != nil {
+			log.Printf("发送节点恢复通知失败，节点ID: %d, 错误: %v", nodeID, err)
+		}
+	}()
+
+	return nil
+}
+
+// 列表接口单次返回的最大记录数
+const maxListLimit = 500
+
+// 将请求的记录数限制在 1 到 maxListLimit 之间，无效时使用默认值
+func clampLimit(limit, defaultLimit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+	return min(limit, maxListLimit)
+}
+
+// 获取节点故障记录
+func (s *NodeService) GetNodeIncidents(nodeID uint, limit int) ([]models.NodeIncident, error) {
+	var incidents []models.NodeIncident
+	tx := s.db.Order("started_at DESC").Limit(clampLimit(limit, 50))
+	if nodeID > 0 {
+		tx = tx.Where("node_id = ?", nodeID)
+	}
+	err := tx.Find(&incidents).Error
+	return incidents, err
+}
+
+// 节点鉴权和限速所需的用户信息
+type NodeUser struct {
+	UserID      uint   `json:"user_id"`
+	Password    string `json:"password"`
+	Port        *int   `json:"port,omitempty"` // 独立端口模式下分配的端口
+	UpSpeed     int    `json:"up_speed"`       // 上行限速（Mbps，0表示不限制）
+	DownSpeed   int    `json:"down_speed"`     // 下行限速（Mbps，0表示不限制）
+	DeviceLimit int    `json:"device_limit"`   // 设备数限制（0表示不限制）
+}
+
+// 获取节点需要同步的可用用户列表（未过期、未超出流量，且节点未分组或当前套餐授权了该节点所在分组），
+// 节点只允许列表中的用户认证
+func (s *NodeService) GetNodeUsers(nodeID uint) ([]NodeUser, error) {
+	var node models.Node
+	if err := s.db.First(&node, nodeID).Error; err != nil {
+		return nil, errors.New("节点不存在")
+	}
+
+	var users []NodeUser
+	tx := s.db.Table("user_configs").
+		Select("user_configs.user_id, user_configs.password, user_configs.port, user_configs.up_speed, user_configs.down_speed, user_configs.device_limit").
+		Joins("JOIN users ON users.id = user_configs.user_id").
+		Where("users.expire_at > ?", time.Now()).
+		Where("users.traffic_limit = 0 OR users.traffic < users.traffic_limit")
+	// 未分组的节点对所有可用用户开放
+	if node.GroupID > 0 {
+		tx = tx.Where("user_configs.user_id IN (?)", s.entitledUsers(node.GroupID))
+	}
+	err := tx.Scan(&users).Error
+	return users, err
+}
+
+// 轮换节点令牌，旧令牌立即失效
+func (s *NodeService) RotateNodeToken(nodeID uint) (string, error) {
+	if _, err := s.GetNode(nodeID); err != nil {
+		return "", err
+	}
+	return s.saveNodeToken(s.db, nodeID)
+}
+
+// 获取节点凭证信息（不含令牌）
+func (s *NodeService) GetNodeCredential(nodeID uint) (*models.NodeCredential, error) {
+	var credential models.NodeCredential
+	if err := s.db.Where("node_id = ?", nodeID).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("节点凭证不存在")
+		}
+		return nil, err
+	}
+	credential.TokenHash = ""
+	return &credential, nil
+}
+
+// 更新节点IP白名单
+func (s *NodeService) UpdateNodeAllowedIPs(nodeID uint, allowedIPs []string) error {
+	for _, ip := range allowedIPs {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return errors.New("无效的IP或CIDR: " + ip)
+			}
+		}
+	}
+
+	result := s.db.Model(&models.NodeCredential{}).
+		Where("node_id = ?", nodeID).
+		Update("allowed_ips", strings.Join(allowedIPs, ","))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("节点凭证不存在")
+	}
+	return nil
+}
+
+// 校验节点令牌和来源IP
+func (s *NodeService) AuthenticateNode(nodeID uint, token, clientIP string) error {
+	var credential models.NodeCredential
+	if err := s.db.Where("node_id = ?", nodeID).First(&credential).Error; err != nil {
+		return errors.New("无效的节点令牌")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashNodeToken(token)), []byte(credential.TokenHash)) != 1 {
+		return errors.New("无效的节点令牌")
+	}
+
+	if !ipAllowed(credential.AllowedIPs, clientIP) {
+		return errors.New("来源IP不在节点白名单内")
+	}
+
+	return s.db.Model(&credential).Updates(map[string]interface{}{
+		"last_used_at": time.Now(),
+		"last_used_ip": clientIP,
+	}).Error
+}
+
+// 生成并保存节点令牌
+func (s *NodeService) saveNodeToken(tx *gorm.DB, nodeID uint) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	credential := models.NodeCredential{NodeID: nodeID}
+	err := tx.Where("node_id = ?", nodeID).
+		Assign(models.NodeCredential{
+			TokenHash: hashNodeToken(token),
+			TokenHint: token[len(token)-6:],
+			RotatedAt: time.Now(),
+		}).
+		FirstOrCreate(&credential).Error
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func hashNodeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 检查IP是否在白名单内，白名单为空表示不限制
+func ipAllowed(allowedIPs, clientIP string) bool {
+	if allowedIPs == "" {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+
+	for _, allowed := range strings.Split(allowedIPs, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if strings.Contains(allowed, "/") {
+			if _, network, err := net.ParseCIDR(allowed); err == nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"hysteria2-panel/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 获取节点自身的分流配置，未设置时返回空配置
+func (s *NodeService) GetNodeRouting(nodeID uint) (*models.NodeRouting, error) {
+	if _, err := s.GetNode(nodeID); err != nil {
+		return nil, err
+	}
+	return s.findRouting("node_id = ?", nodeID)
+}
+
+// 设置节点自身的分流配置
+func (s *NodeService) SetNodeRouting(nodeID uint, acl string, outbounds []models.NodeOutbound) error {
+	if _, err := s.GetNode(nodeID); err != nil {
+		return err
+	}
+	return s.saveRouting(&models.NodeRouting{NodeID: nodeID, ACL: acl, Outbounds: outbounds})
+}
+
+// 获取节点分组的分流配置，未设置时返回空配置
+func (s *NodeService) GetGroupRouting(groupID uint) (*models.NodeRouting, error) {
+	if err := s.db.First(&models.NodeGroup{}, groupID).Error; err != nil {
+		return nil, errors.New("节点分组不存在")
+	}
+	return s.findRouting("group_id = ? AND node_id = 0", groupID)
+}
+
+// 设置节点分组的分流配置，对分组内所有节点生效
+func (s *NodeService) SetGroupRouting(groupID uint, acl string, outbounds []models.NodeOutbound) error {
+	if err := s.db.First(&models.NodeGroup{}, groupID).Error; err != nil {
+		return errors.New("节点分组不存在")
+	}
+	return s.saveRouting(&models.NodeRouting{GroupID: groupID, ACL: acl, Outbounds: outbounds})
+}
+
+// 节点生效的分流配置：节点的 ACL 规则排在分组规则之前优先匹配，
+// 出站合并时同名出站以节点配置为准，节点的第一个出站作为默认出站
+func (s *NodeService) GetEffectiveRouting(node *models.Node) (*models.NodeRouting, error) {
+	routing, err := s.findRouting("node_id = ?", node.ID)
+	if err != nil {
+		return nil, err
+	}
+	if node.GroupID == 0 {
+		return routing, nil
+	}
+
+	group, err := s.findRouting("group_id = ? AND node_id = 0", node.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &models.NodeRouting{
+		NodeID:    node.ID,
+		ACL:       strings.TrimSpace(routing.ACL + "\n" + group.ACL),
+		Outbounds: routing.Outbounds,
+	}
+	names := make(map[string]bool, len(routing.Outbounds))
+	for _, o := range routing.Outbounds {
+		names[strings.ToLower(o.Name)] = true
+	}
+	for _, o := range group.Outbounds {
+		if !names[strings.ToLower(o.Name)] {
+			merged.Outbounds = append(merged.Outbounds, o)
+		}
+	}
+
+	if err := validateRouting(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func (s *NodeService) findRouting(query string, id uint) (*models.NodeRouting, error) {
+	var routing models.NodeRouting
+	err := s.db.Where(query, id).First(&routing).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &routing, nil
+}
+
+func (s *NodeService) saveRouting(routing *models.NodeRouting) error {
+	routing.ACL = strings.TrimSpace(routing.ACL)
+	if err := validateRouting(routing); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.NodeRouting
+		err := tx.Where("node_id = ? AND group_id = ?", routing.NodeID, routing.GroupID).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		routing.ID = existing.ID
+		routing.CreatedAt = existing.CreatedAt
+		return tx.Save(routing).Error
+	})
+}
+
+// 按服务端配置的规则校验 ACL 语法和出站
+func validateRouting(routing *models.NodeRouting) error {
+	return validateACLOutbounds(routingACL(routing), routingOutbounds(routing))
+}
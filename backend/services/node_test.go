@@ -0,0 +1,81 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"hysteria2-panel/models"
+
+	"gorm.io/gorm"
+)
+
+func TestIPAllowed(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowedIPs string
+		clientIP   string
+		want       bool
+	}{
+		{"白名单为空不限制", "", "203.0.113.5", true},
+		{"匹配单个IP", "203.0.113.5", "203.0.113.5", true},
+		{"不匹配单个IP", "203.0.113.5", "203.0.113.6", false},
+		{"匹配CIDR", "10.0.0.1, 203.0.113.0/24", "203.0.113.200", true},
+		{"不匹配CIDR", "203.0.113.0/24", "198.51.100.1", false},
+		{"IPv6", "2001:db8::/32", "2001:db8::1", true},
+		{"无效的来源IP", "203.0.113.0/24", "unknown", false},
+		{"忽略无效的白名单项", "not-an-ip, 203.0.113.5", "203.0.113.5", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ipAllowed(tt.allowedIPs, tt.clientIP); got != tt.want {
+				t.Errorf("ipAllowed(%q, %q) = %v, want %v", tt.allowedIPs, tt.clientIP, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateNode(t *testing.T) {
+	const token = "node-token"
+	tests := []struct {
+		name       string
+		token      string
+		allowedIPs string
+		clientIP   string
+		wantErr    string
+	}{
+		{"令牌正确", token, "", "203.0.113.5", ""},
+		{"令牌错误", "wrong-token", "", "203.0.113.5", "无效的节点令牌"},
+		{"来源IP在白名单内", token, "203.0.113.0/24", "203.0.113.5", ""},
+		{"来源IP不在白名单内", token, "203.0.113.0/24", "198.51.100.1", "来源IP不在节点白名单内"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, statements := newDryRunDB(t)
+			// 查询节点凭证时返回预设的凭证
+			db.Callback().Query().After("gorm:query").Register("test:credential", func(tx *gorm.DB) {
+				if credential, ok := tx.Statement.Dest.(*models.NodeCredential); ok {
+					*credential = models.NodeCredential{ID: 1, NodeID: 3, TokenHash: hashNodeToken(token), AllowedIPs: tt.allowedIPs}
+				}
+			})
+
+			err := NewNodeService(db, nil).AuthenticateNode(3, tt.token, tt.clientIP)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("AuthenticateNode() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("AuthenticateNode() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if len(*statements) == 0 || !strings.Contains((*statements)[0], "node_id = 3") {
+				t.Errorf("应按节点ID查询凭证: %v", *statements)
+			}
+			// 认证成功时记录最后使用的IP，失败时不更新
+			updated := len(*statements) == 2 && strings.Contains((*statements)[1], "`last_used_ip`='"+tt.clientIP+"'")
+			if updated != (tt.wantErr == "") {
+				t.Errorf("最后使用记录更新不符合预期: %v", *statements)
+			}
+		})
+	}
+}
@@ -1,22 +1,30 @@
 package services
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"html"
 	"hysteria2-panel/models"
+	"net/http"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type NotificationService struct {
-	db          *gorm.DB
-	mailService *MailService
+	db             *gorm.DB
+	mailService    *MailService
+	settingService *SettingService
+	client         *http.Client
 }
 
-func NewNotificationService(db *gorm.DB, mailService *MailService) *NotificationService {
+func NewNotificationService(db *gorm.DB, mailService *MailService, settingService *SettingService) *NotificationService {
 	return &NotificationService{
-		db:          db,
-		mailService: mailService,
+		db:             db,
+		mailService:    mailService,
+		settingService: settingService,
+		client:         &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
@@ -68,3 +76,118 @@ func (s *NotificationService) SendTrafficNotices() error {
 
 	return nil
 }
+
+// 发送管理员告警，同时通过邮件和Webhook通知
+func (s *NotificationService) SendAdminAlert(event, subject, content string, data map[string]interface{}) error {
+	config, err := s.settingService.GetAlertConfig()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if len(config.Emails) > 0 {
+		body := fmt.Sprintf("<h3>%s</h3><p>%s</p>", html.EscapeString(subject), html.EscapeString(content))
+		if err := s.mailService.SendMail(config.Emails, subject, body); err != nil {
+			errs = append(errs, fmt.Errorf("邮件告警发送失败: %v", err))
+		}
+	}
+
+	if config.WebhookURL != "" {
+		payload, err := json.Marshal(map[string]interface{}{
+			"event":   event,
+			"subject": subject,
+			"content": content,
+			"data":    data,
+			"time":    time.Now().Unix(),
+		})
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.client.Post(config.WebhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Webhook告警发送失败: %v", err))
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				errs = append(errs, fmt.Errorf("Webhook告警发送失败: HTTP %d", resp.StatusCode))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// 发送节点离线告警
+func (s *NotificationService) SendNodeDownAlert(node *models.Node, incident *models.NodeIncident) error {
+	subject := fmt.Sprintf("节点离线: %s", node.Name)
+	content := fmt.Sprintf("节点 %s（%s:%d）自 %s 起未上报状态，已标记为离线。",
+		node.Name, node.Host, node.Port, node.LastPing.Format("2006-01-02 15:04:05"))
+
+	return s.SendAdminAlert("node.down", subject, content, map[string]interface{}{
+		"node_id":     node.ID,
+		"node_name":   node.Name,
+		"incident_id": incident.ID,
+		"started_at":  incident.StartedAt.Unix(),
+	})
+}
+
+// 发送节点恢复通知
+func (s *NotificationService) SendNodeRecoveredAlert(node *models.Node, incident *models.NodeIncident) error {
+	subject := fmt.Sprintf("节点恢复: %s", node.Name)
+	content := fmt.Sprintf("节点 %s（%s:%d）已恢复在线，离线时长 %s。",
+		node.Name, node.Host, node.Port, time.Duration(incident.Duration)*time.Second)
+
+	return s.SendAdminAlert("node.recovered", subject, content, map[string]interface{}{
+		"node_id":     node.ID,
+		"node_name":   node.Name,
+		"incident_id": incident.ID,
+		"duration":    incident.Duration,
+	})
+}
+
+// 发送节点不可达告警
+func (s *NotificationService) SendNodeUnreachableAlert(node *models.Node, probe *models.NodeProbe) error {
+	subject := fmt.Sprintf("节点不可达: %s", node.Name)
+	content := fmt.Sprintf("面板探测节点 %s（%s:%d）的 QUIC 握手全部失败：%s",
+		node.Name, node.Host, node.Port, probe.Error)
+
+	return s.SendAdminAlert("node.unreachable", subject, content, map[string]interface{}{
+		"node_id":   node.ID,
+		"node_name": node.Name,
+		"error":     probe.Error,
+		"probed_at": probe.ProbedAt.Unix(),
+	})
+}
+
+// 发送节点恢复可达通知
+func (s *NotificationService) SendNodeReachableAlert(node *models.Node, probe *models.NodeProbe) error {
+	subject := fmt.Sprintf("节点恢复可达: %s", node.Name)
+	content := fmt.Sprintf("节点 %s（%s:%d）已恢复可达，握手延迟 %dms，丢包率 %.0f%%。",
+		node.Name, node.Host, node.Port, probe.Latency, probe.PacketLoss)
+
+	return s.SendAdminAlert("node.reachable", subject, content, map[string]interface{}{
+		"node_id":     node.ID,
+		"node_name":   node.Name,
+		"latency":     probe.Latency,
+		"packet_loss": probe.PacketLoss,
+		"probed_at":   probe.ProbedAt.Unix(),
+	})
+}
+
+// 发送订阅链接疑似泄露告警
+func (s *NotificationService) SendSubscriptionLeakAlert(user *models.User, distinctIPs int64, window time.Duration) error {
+	subject := fmt.Sprintf("订阅链接疑似泄露: %s", user.Username)
+	content := fmt.Sprintf("用户 %s（ID: %d）的订阅链接在最近 %s 内被 %d 个不同IP拉取，请确认是否需要重置订阅。",
+		user.Username, user.ID, window, distinctIPs)
+
+	return s.SendAdminAlert("subscription.leak", subject, content, map[string]interface{}{
+		"user_id":      user.ID,
+		"username":     user.Username,
+		"distinct_ips": distinctIPs,
+		"window":       int64(window.Seconds()),
+	})
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"hysteria2-panel/models"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 超过该时间未再上报的在线记录视为已下线
+const onlineTimeout = 3 * time.Minute
+
+type OnlineService struct {
+	db    *gorm.DB
+	mutex sync.Mutex
+	// 用户在各节点上的在线客户端，key 为用户ID
+	clients map[uint]map[uint]*OnlineClient
+	// 待下发到节点的踢出指令，key 为节点ID
+	kicks map[uint]map[uint]bool
+}
+
+// 用户在一个节点上的在线客户端
+type OnlineClient struct {
+	NodeID    uint      `json:"node_id"`
+	Devices   int       `json:"devices"` // 在线客户端数
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+func NewOnlineService(db *gorm.DB) *OnlineService {
+	return &OnlineService{
+		db:      db,
+		clients: make(map[uint]map[uint]*OnlineClient),
+		kicks:   make(map[uint]map[uint]bool),
+	}
+}
+
+// 记录节点上报的在线客户端数（用户ID -> 客户端数，即 Hysteria2 流量统计接口 /online 的返回），
+// 返回该节点需要踢下线的用户
+func (s *OnlineService) ReportOnline(nodeID uint, online map[uint]int) ([]uint, error) {
+	userIDs := make([]uint, 0, len(online))
+	for userID := range online {
+		userIDs = append(userIDs, userID)
+	}
+
+	limits := make(map[uint]int)
+	if len(userIDs) > 0 {
+		var configs []models.UserConfig
+		if err := s.db.Select("user_id, device_limit").
+			Where("user_id IN ? AND device_limit > 0", userIDs).
+			Find(&configs).Error; err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			limits[config.UserID] = config.DeviceLimit
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+
+	// 用本次上报替换该节点之前的在线记录，同时清理过期记录
+	for userID, clients := range s.clients {
+		for id, client := range clients {
+			if (id == nodeID && online[userID] <= 0) || now.Sub(client.LastSeen) > onlineTimeout {
+				delete(clients, id)
+			}
+		}
+		if len(clients) == 0 {
+			delete(s.clients, userID)
+		}
+	}
+
+	for userID, devices := range online {
+		if devices <= 0 {
+			continue
+		}
+		clients, ok := s.clients[userID]
+		if !ok {
+			clients = make(map[uint]*OnlineClient)
+			s.clients[userID] = clients
+		}
+
+		if client, ok := clients[nodeID]; ok {
+			client.Devices = devices
+			client.LastSeen = now
+		} else {
+			clients[nodeID] = &OnlineClient{NodeID: nodeID, Devices: devices, FirstSeen: now, LastSeen: now}
+		}
+
+		if limit, ok := limits[userID]; ok {
+			s.enforceDeviceLimit(userID, limit)
+		}
+	}
+
+	kicks := make([]uint, 0, len(s.kicks[nodeID]))
+	for userID := range s.kicks[nodeID] {
+		kicks = append(kicks, userID)
+	}
+	delete(s.kicks, nodeID)
+
+	return kicks, nil
+}
+
+// 超出设备数限制时，从最晚上线的节点开始下发踢出指令，直到剩余设备数不超过限制。
+// Hysteria2 只能按用户踢出，节点上该用户的连接会全部断开；设备重连后仍超出限制时会再次被踢出
+func (s *OnlineService) enforceDeviceLimit(userID uint, limit int) {
+	clients := make([]*OnlineClient, 0, len(s.clients[userID]))
+	total := 0
+	for _, client := range s.clients[userID] {
+		clients = append(clients, client)
+		total += client.Devices
+	}
+
+	if total <= limit {
+		return
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].FirstSeen.After(clients[j].FirstSeen)
+	})
+
+	for _, client := range clients {
+		if total <= limit {
+			break
+		}
+		if s.kicks[client.NodeID] == nil {
+			s.kicks[client.NodeID] = make(map[uint]bool)
+		}
+		s.kicks[client.NodeID][userID] = true
+		total -= client.Devices
+		delete(s.clients[userID], client.NodeID)
+	}
+}
+
+// 对用户当前在线的所有节点下发踢出指令，如凭证变更后断开旧连接
+func (s *OnlineService) KickUser(userID uint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for nodeID := range s.clients[userID] {
+		if s.kicks[nodeID] == nil {
+			s.kicks[nodeID] = make(map[uint]bool)
+		}
+		s.kicks[nodeID][userID] = true
+	}
+	delete(s.clients, userID)
+}
+
+// 获取所有在线用户的客户端
+func (s *OnlineService) GetOnlineUsers() map[uint][]OnlineClient {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := make(map[uint][]OnlineClient, len(s.clients))
+	for userID, clients := range s.clients {
+		for _, client := range clients {
+			if time.Since(client.LastSeen) > onlineTimeout {
+				continue
+			}
+			result[userID] = append(result[userID], *client)
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 构造用户在多个节点上的在线记录，offsets 为各节点相对基准时间的上线偏移
+func newOnlineServiceWithClients(userID uint, devices map[uint]int, offsets map[uint]time.Duration) *OnlineService {
+	s := NewOnlineService(nil)
+	base := time.Now().Add(-time.Hour)
+	s.clients[userID] = make(map[uint]*OnlineClient)
+	for nodeID, count := range devices {
+		firstSeen := base.Add(offsets[nodeID])
+		s.clients[userID][nodeID] = &OnlineClient{NodeID: nodeID, Devices: count, FirstSeen: firstSeen, LastSeen: time.Now()}
+	}
+	return s
+}
+
+// 返回指定用户被下发踢出指令的节点
+func kickedNodes(s *OnlineService, userID uint) []uint {
+	var nodes []uint
+	for nodeID, users := range s.kicks {
+		if users[userID] {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	return nodes
+}
+
+func TestEnforceDeviceLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		devices   map[uint]int
+		offsets   map[uint]time.Duration
+		limit     int
+		wantKicks []uint
+		wantLeft  []uint
+	}{
+		{"未超出限制", map[uint]int{1: 1, 2: 1}, map[uint]time.Duration{2: time.Minute}, 2, nil, []uint{1, 2}},
+		{"踢出最晚上线的节点", map[uint]int{1: 1, 2: 1, 3: 1}, map[uint]time.Duration{2: time.Minute, 3: 2 * time.Minute}, 2, []uint{3}, []uint{1, 2}},
+		{"依次踢出直到不超出限制", map[uint]int{1: 2, 2: 1, 3: 1}, map[uint]time.Duration{2: time.Minute, 3: 2 * time.Minute}, 2, []uint{2, 3}, []uint{1}},
+		{"单个节点超出限制", map[uint]int{1: 3}, nil, 2, []uint{1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newOnlineServiceWithClients(7, tt.devices, tt.offsets)
+			s.enforceDeviceLimit(7, tt.limit)
+
+			if got := kickedNodes(s, 7); !reflect.DeepEqual(got, tt.wantKicks) {
+				t.Errorf("踢出节点 = %v, want %v", got, tt.wantKicks)
+			}
+			var left []uint
+			for nodeID := range s.clients[7] {
+				left = append(left, nodeID)
+			}
+			sort.Slice(left, func(i, j int) bool { return left[i] < left[j] })
+			if !reflect.DeepEqual(left, tt.wantLeft) {
+				t.Errorf("剩余节点 = %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+// 踢出指令在对应节点下次上报时下发，且只下发一次
+func TestKickUserDeliveredOnce(t *testing.T) {
+	s := newOnlineServiceWithClients(7, map[uint]int{1: 1, 2: 1}, nil)
+	s.KickUser(7)
+
+	kicks, err := s.ReportOnline(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(kicks, []uint{7}) {
+		t.Errorf("节点1应收到踢出指令: %v", kicks)
+	}
+	if kicks, _ := s.ReportOnline(1, nil); len(kicks) != 0 {
+		t.Errorf("踢出指令不应重复下发: %v", kicks)
+	}
+	if _, ok := s.kicks[2][7]; !ok {
+		t.Error("节点2的踢出指令应保留到其下次上报")
+	}
+}
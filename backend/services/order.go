@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"hysteria2-panel/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OrderService struct {
+	db *gorm.DB
+}
+
+func NewOrderService(db *gorm.DB) *OrderService {
+	return &OrderService{db: db}
+}
+
+// 订单查询条件
+type OrderQuery struct {
+	Page          int
+	PageSize      int
+	UserID        uint
+	PlanID        uint
+	OrderNo       string
+	PaymentStatus *int
+	PaymentMethod string
+	StartAt       time.Time
+	EndAt         time.Time
+}
+
+// 获取订单列表
+func (s *OrderService) GetOrders(query *OrderQuery) ([]models.Order, int64, error) {
+	var orders []models.Order
+	var total int64
+
+	tx := s.db.Model(&models.Order{})
+	if query.UserID > 0 {
+		tx = tx.Where("user_id = ?", query.UserID)
+	}
+	if query.PlanID > 0 {
+		tx = tx.Where("plan_id = ?", query.PlanID)
+	}
+	if query.OrderNo != "" {
+		tx = tx.Where("order_no LIKE ?", query.OrderNo+"%")
+	}
+	if query.PaymentStatus != nil {
+		tx = tx.Where("payment_status = ?", *query.PaymentStatus)
+	}
+	if query.PaymentMethod != "" {
+		tx = tx.Where("payment_method = ?", query.PaymentMethod)
+	}
+	if !query.StartAt.IsZero() {
+		tx = tx.Where("created_at >= ?", query.StartAt)
+	}
+	if !query.EndAt.IsZero() {
+		tx = tx.Where("created_at < ?", query.EndAt)
+	}
+
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (query.Page - 1) * query.PageSize
+	if err := tx.Order("id DESC").Offset(offset).Limit(query.PageSize).Find(&orders).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return orders, total, nil
+}
+
+// 根据订单号获取订单
+func (s *OrderService) GetOrderByNo(orderNo string) (*models.Order, error) {
+	var order models.Order
+	if err := s.db.Where("order_no = ?", orderNo).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("订单不存在")
+		}
+		return nil, err
+	}
+	return &order, nil
+}
+
+// 用户取消未支付订单
+func (s *OrderService) CancelOrder(userID uint, orderNo string) error {
+	result := s.db.Model(&models.Order{}).
+		Where("order_no = ? AND user_id = ? AND payment_status = ?", orderNo, userID, 0).
+		Update("payment_status", 2)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订单不存在或无法取消")
+	}
+	return nil
+}
+
+// 将超时未支付的订单标记为已过期。升级前创建的订单没有过期时间（NULL），不会被过期
+func (s *OrderService) ExpireOrders() (int64, error) {
+	result := s.db.Model(&models.Order{}).
+		Where("payment_status = ? AND expire_at IS NOT NULL AND expire_at < ?", 0, time.Now()).
+		Update("payment_status", 3)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 创建只生成 SQL、不连接数据库的 gorm 实例，返回记录生成语句的切片
+func newDryRunDB(t *testing.T) (*gorm.DB, *[]string) {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "test:test@tcp(127.0.0.1:3306)/test?parseTime=True",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statements []string
+	record := func(tx *gorm.DB) {
+		statements = append(statements, tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...))
+	}
+	db.Callback().Query().After("gorm:query").Register("test:record", record)
+	db.Callback().Update().After("gorm:update").Register("test:record", record)
+	return db, &statements
+}
+
+func TestOrderQueryScope(t *testing.T) {
+	status := 1
+	tests := []struct {
+		name    string
+		query   OrderQuery
+		want    []string
+		notWant []string
+	}{
+		{"按用户过滤", OrderQuery{Page: 1, PageSize: 10, UserID: 7}, []string{"user_id = 7"}, nil},
+		{"不指定用户", OrderQuery{Page: 1, PageSize: 10}, nil, []string{"user_id"}},
+		{"组合条件", OrderQuery{Page: 1, PageSize: 10, UserID: 7, OrderNo: "2024", PaymentStatus: &status},
+			[]string{"user_id = 7", "order_no LIKE '2024%'", "payment_status = 1"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, statements := newDryRunDB(t)
+			if _, _, err := NewOrderService(db).GetOrders(&tt.query); err != nil {
+				t.Fatal(err)
+			}
+			if len(*statements) != 2 {
+				t.Fatalf("应生成计数和列表两条查询: %v", *statements)
+			}
+			for _, sql := range *statements {
+				for _, want := range tt.want {
+					if !strings.Contains(sql, want) {
+						t.Errorf("查询缺少条件 %q: %s", want, sql)
+					}
+				}
+				for _, notWant := range tt.notWant {
+					if strings.Contains(sql, notWant) {
+						t.Errorf("查询不应包含 %q: %s", notWant, sql)
+					}
+				}
+			}
+		})
+	}
+}
+
+// 没有过期时间的旧订单不会被过期
+func TestExpireOrdersSkipsLegacyOrders(t *testing.T) {
+	db, statements := newDryRunDB(t)
+	if _, err := NewOrderService(db).ExpireOrders(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*statements) != 1 || !strings.Contains((*statements)[0], "expire_at IS NOT NULL") {
+		t.Errorf("过期查询应排除没有过期时间的订单: %v", *statements)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"hysteria2-panel/models"
+	"log"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -12,14 +14,16 @@ type PaymentService struct {
 	db             *gorm.DB
 	settingService *SettingService
 	planService    *PlanService
+	invoiceService *InvoiceService
 	providers      map[string]models.PaymentProvider
 }
 
-func NewPaymentService(db *gorm.DB, settingService *SettingService, planService *PlanService) *PaymentService {
+func NewPaymentService(db *gorm.DB, settingService *SettingService, planService *PlanService, invoiceService *InvoiceService) *PaymentService {
 	return &PaymentService{
 		db:             db,
 		settingService: settingService,
 		planService:    planService,
+		invoiceService: invoiceService,
 		providers:      make(map[string]models.PaymentProvider),
 	}
 }
@@ -29,6 +33,15 @@ func (s *PaymentService) RegisterProvider(name string, provider models.PaymentPr
 	s.providers[name] = provider
 }
 
+// 获取可用的支付方式及其支持的币种
+func (s *PaymentService) GetPaymentMethods() map[string][]string {
+	methods := make(map[string][]string, len(s.providers))
+	for name, provider := range s.providers {
+		methods[name] = provider.SupportedCurrencies()
+	}
+	return methods
+}
+
 // 创建支付
 func (s *PaymentService) CreatePayment(orderNo string, method string) (string, error) {
 	var order models.Order
@@ -40,11 +53,19 @@ func (s *PaymentService) CreatePayment(orderNo string, method string) (string, e
 		return "", errors.New("订单状态异常")
 	}
 
+	if !order.ExpireAt.IsZero() && time.Now().After(order.ExpireAt) {
+		return "", errors.New("订单已过期")
+	}
+
 	provider, ok := s.providers[method]
 	if !ok {
 		return "", fmt.Errorf("不支持的支付方式: %s", method)
 	}
 
+	if !supportsCurrency(provider, order.Currency) {
+		return "", fmt.Errorf("支付方式 %s 不支持币种 %s", method, order.Currency)
+	}
+
 	// 更新订单支付方式
 	if err := s.db.Model(&order).Update("payment_method", method).Error; err != nil {
 		return "", err
@@ -65,7 +86,7 @@ func (s *PaymentService) HandleCallback(method string, params map[string]string)
 		return errors.New("回调验证失败")
 	}
 
-	return s.planService.HandlePayment(orderNo, method)
+	return s.completePayment(orderNo, method)
 }
 
 // 查询支付状态
@@ -86,3 +107,62 @@ func (s *PaymentService) QueryPaymentStatus(orderNo string) (bool, error) {
 
 	return provider.QueryPayment(orderNo)
 }
+
+// 对账：主动查询待支付订单的支付结果，补偿丢失的支付回调
+func (s *PaymentService) ReconcileOrders() error {
+	var orders []models.Order
+	// 已过期一天以内的订单也需要查询，避免用户在过期前付款但回调丢失
+	err := s.db.Where("payment_method <> '' AND (payment_status = ? OR (payment_status = ? AND expire_at > ?))",
+		0, 3, time.Now().Add(-24*time.Hour)).
+		Find(&orders).Error
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		provider, ok := s.providers[order.PaymentMethod]
+		if !ok {
+			continue
+		}
+
+		paid, err := provider.QueryPayment(order.OrderNo)
+		if err != nil {
+			log.Printf("查询订单支付状态失败，订单号: %s, 错误: %v", order.OrderNo, err)
+			continue
+		}
+		if !paid {
+			continue
+		}
+
+		if err := s.completePayment(order.OrderNo, order.PaymentMethod); err != nil {
+			log.Printf("对账处理订单失败，订单号: %s, 错误: %v", order.OrderNo, err)
+		}
+	}
+
+	return nil
+}
+
+// 订单入账，成功后异步开具发票
+func (s *PaymentService) completePayment(orderNo string, method string) error {
+	if err := s.planService.HandlePayment(orderNo, method); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.invoiceService.HandleOrderPaid(orderNo); err != nil {
+			log.Printf("开具发票失败，订单号: %s, 错误: %v", orderNo, err)
+		}
+	}()
+
+	return nil
+}
+
+// 检查支付提供商是否支持指定币种
+func supportsCurrency(provider models.PaymentProvider, currency string) bool {
+	for _, c := range provider.SupportedCurrencies() {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
@@ -1,44 +1,200 @@
 package services
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"hysteria2-panel/models"
+	"math"
+	"math/big"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type PlanService struct {
-	db *gorm.DB
+	db              *gorm.DB
+	settingService  *SettingService
+	currencyService *CurrencyService
+	configManager   *ConfigManagerService
 }
 
-func NewPlanService(db *gorm.DB) *PlanService {
-	return &PlanService{db: db}
+func NewPlanService(db *gorm.DB, settingService *SettingService, currencyService *CurrencyService, configManager *ConfigManagerService) *PlanService {
+	return &PlanService{
+		db:              db,
+		settingService:  settingService,
+		currencyService: currencyService,
+		configManager:   configManager,
+	}
 }
 
 // 创建套餐
 func (s *PlanService) CreatePlan(plan *models.Plan) error {
+	if plan.Currency == "" {
+		plan.Currency = models.CurrencyCNY
+	}
+	if !models.IsSupportedCurrency(plan.Currency) {
+		return fmt.Errorf("不支持的币种: %s", plan.Currency)
+	}
+	for _, price := range plan.Prices {
+		if !models.IsSupportedCurrency(price.Currency) {
+			return fmt.Errorf("不支持的币种: %s", price.Currency)
+		}
+	}
+	if err := s.settingService.CheckRoutingTemplate(plan.RoutingTemplate); err != nil {
+		return err
+	}
+	if err := validatePlanBandwidth(plan.BandwidthUp, plan.BandwidthDown); err != nil {
+		return err
+	}
 	return s.db.Create(plan).Error
 }
 
 // 获取套餐列表
 func (s *PlanService) GetPlans() ([]models.Plan, error) {
 	var plans []models.Plan
-	err := s.db.Find(&plans).Error
+	err := s.db.Preload("Prices").Preload("NodeGroups").Find(&plans).Error
 	return plans, err
 }
 
+// 设置套餐的多币种固定价格，覆盖原有设置
+func (s *PlanService) SetPlanPrices(planID uint, prices []models.PlanPrice) error {
+	for _, price := range prices {
+		if !models.IsSupportedCurrency(price.Currency) {
+			return fmt.Errorf("不支持的币种: %s", price.Currency)
+		}
+		if price.Amount < 0 {
+			return errors.New("价格不能为负数")
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var plan models.Plan
+		if err := tx.First(&plan, planID).Error; err != nil {
+			return errors.New("套餐不存在")
+		}
+
+		if err := tx.Where("plan_id = ?", planID).Delete(&models.PlanPrice{}).Error; err != nil {
+			return err
+		}
+
+		for _, price := range prices {
+			price.ID = 0
+			price.PlanID = planID
+			if err := tx.Create(&price).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// 设置套餐可使用的节点分组
+func (s *PlanService) SetPlanNodeGroups(planID uint, groupIDs []uint) error {
+	// 去掉重复的分组ID，否则查询到的分组数量与请求不一致
+	seen := make(map[uint]bool, len(groupIDs))
+	unique := make([]uint, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	groupIDs = unique
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var plan models.Plan
+		if err := tx.First(&plan, planID).Error; err != nil {
+			return errors.New("套餐不存在")
+		}
+
+		var groups []models.NodeGroup
+		if len(groupIDs) > 0 {
+			if err := tx.Where("id IN ?", groupIDs).Find(&groups).Error; err != nil {
+				return err
+			}
+			if len(groups) != len(groupIDs) {
+				return errors.New("节点分组不存在")
+			}
+		}
+
+		return tx.Model(&plan).Association("NodeGroups").Replace(groups)
+	})
+}
+
+// 计算套餐在指定币种下的价格，优先使用固定价格，否则按汇率换算基准价格
+func (s *PlanService) GetPlanPrice(plan *models.Plan, currency string) (int64, float64, error) {
+	if currency == plan.Currency {
+		return plan.Price, 1, nil
+	}
+
+	var price models.PlanPrice
+	err := s.db.Where("plan_id = ? AND currency = ?", plan.ID, currency).First(&price).Error
+	if err == nil {
+		rate := 0.0
+		if plan.Price > 0 {
+			rate = float64(price.Amount) / math.Pow10(models.CurrencyDigits(currency)) /
+				(float64(plan.Price) / math.Pow10(models.CurrencyDigits(plan.Currency)))
+		}
+		return price.Amount, rate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, 0, err
+	}
+
+	return s.currencyService.Convert(plan.Price, plan.Currency, currency)
+}
+
 // 更新套餐
 func (s *PlanService) UpdatePlan(id uint, updates map[string]interface{}) error {
-	result := s.db.Model(&models.Plan{}).Where("id = ?", id).Updates(updates)
-	if result.Error != nil {
-		return result.Error
+	if name, ok := updates["routing_template"]; ok {
+		value, _ := name.(string)
+		if err := s.settingService.CheckRoutingTemplate(value); err != nil {
+			return err
+		}
 	}
-	if result.RowsAffected == 0 {
-		return errors.New("套餐不存在")
+	for _, field := range []string{"bandwidth_up", "bandwidth_down"} {
+		if value, ok := updates[field]; ok {
+			bandwidth, _ := value.(string)
+			if err := validatePlanBandwidth(bandwidth); err != nil {
+				return err
+			}
+		}
 	}
-	return nil
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Plan{}).Where("id = ?", id).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("套餐不存在")
+		}
+
+		changed := false
+		for _, field := range []string{"speed_limit", "bandwidth_up", "bandwidth_down", "device_limit"} {
+			if _, ok := updates[field]; ok {
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+
+		// 套餐带宽或设备数变化时，同步到正在使用该套餐的用户
+		var plan models.Plan
+		if err := tx.First(&plan, id).Error; err != nil {
+			return err
+		}
+
+		activeUsers := tx.Model(&models.Subscription{}).
+			Select("user_id").
+			Where("plan_id = ? AND status = 1 AND end_at > ?", id, time.Now())
+		return tx.Model(&models.UserConfig{}).
+			Where("user_id IN (?)", activeUsers).
+			Updates(planLimitUpdates(&plan)).Error
+	})
 }
 
 // 订阅套餐
@@ -83,23 +239,60 @@ func (s *PlanService) Subscribe(userID, planID uint) error {
 			return err
 		}
 
+		// 确保用户已有认证配置（早于自动分配注册的用户可能没有），再同步套餐的限速和设备数
+		if err := s.configManager.ProvisionUserConfig(tx, userID); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.UserConfig{}).Where("user_id = ?", userID).Updates(planLimitUpdates(&plan)).Error; err != nil {
+			return err
+		}
+
 		return nil
 	})
 }
 
-// 创建订单
-func (s *PlanService) CreateOrder(userID, planID uint) (*models.Order, error) {
+// 创建订单，currency 为空时使用默认币种
+func (s *PlanService) CreateOrder(userID, planID uint, currency string) (*models.Order, error) {
 	var plan models.Plan
 	if err := s.db.First(&plan, planID).Error; err != nil {
 		return nil, errors.New("套餐不存在")
 	}
 
+	if currency == "" {
+		currencyConfig, err := s.settingService.GetCurrencyConfig()
+		if err != nil {
+			return nil, err
+		}
+		currency = currencyConfig.DefaultCurrency
+	}
+	if !models.IsSupportedCurrency(currency) {
+		return nil, fmt.Errorf("不支持的币种: %s", currency)
+	}
+
+	amount, rate, err := s.GetPlanPrice(&plan, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	orderConfig, err := s.settingService.GetOrderConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	orderNo, err := generateOrderNo()
+	if err != nil {
+		return nil, err
+	}
+
 	order := &models.Order{
 		UserID:        userID,
 		PlanID:        planID,
-		OrderNo:       fmt.Sprintf("%d%d%d", userID, planID, time.Now().Unix()),
-		Amount:        plan.Price,
+		OrderNo:       orderNo,
+		Amount:        amount,
+		Currency:      currency,
+		ExchangeRate:  rate,
 		PaymentStatus: 0,
+		ExpireAt:      time.Now().Add(time.Duration(orderConfig.ExpireMinutes) * time.Minute),
 	}
 
 	if err := s.db.Create(order).Error; err != nil {
@@ -117,7 +310,8 @@ func (s *PlanService) HandlePayment(orderNo string, method string) error {
 			return errors.New("订单不存在")
 		}
 
-		if order.PaymentStatus != 0 {
+		// 已过期的订单仍可能在过期后才收到支付结果，同样需要入账
+		if order.PaymentStatus != 0 && order.PaymentStatus != 3 {
 			return errors.New("订单状态异常")
 		}
 
@@ -135,3 +329,41 @@ func (s *PlanService) HandlePayment(orderNo string, method string) error {
 		return s.Subscribe(order.UserID, order.PlanID)
 	})
 }
+
+// 套餐限制对应的用户配置字段
+func planLimitUpdates(plan *models.Plan) map[string]interface{} {
+	up, down := plan.SpeedLimit, plan.SpeedLimit
+	if plan.BandwidthUp != "" {
+		up = bandwidthMbps(plan.BandwidthUp)
+	}
+	if plan.BandwidthDown != "" {
+		down = bandwidthMbps(plan.BandwidthDown)
+	}
+	return map[string]interface{}{
+		"up_speed":     up,
+		"down_speed":   down,
+		"device_limit": plan.DeviceLimit,
+	}
+}
+
+// 校验套餐的上下行带宽
+func validatePlanBandwidth(bandwidths ...string) error {
+	for _, bandwidth := range bandwidths {
+		if bandwidth == "" {
+			continue
+		}
+		if _, err := ParseBandwidth(bandwidth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 生成订单号：时间戳 + 10位随机数，不携带用户和套餐信息
+func generateOrderNo() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1e10))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%010d", time.Now().Format("20060102150405"), n.Int64()), nil
+}
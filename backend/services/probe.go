@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"hysteria2-panel/models"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"gorm.io/gorm"
+)
+
+// 节点探测参数
+const (
+	probeAttempts    = 4                  // 每次探测的握手次数，用于估算丢包率
+	probeTimeout     = 3 * time.Second    // 单次握手超时
+	probeConcurrency = 10                 // 同时探测的节点数
+	probeRetention   = 7 * 24 * time.Hour // 探测记录保留时间
+)
+
+// ProbeService 从面板主动对节点发起 QUIC 握手，检查客户端视角下节点是否可达。
+// Hysteria2 服务端对外表现为 HTTP/3 服务，使用 h3 ALPN 完成 QUIC 和 TLS 握手即可确认
+// UDP 端口可达且证书有效，不需要用户凭证。启用了 Salamander 混淆的节点会丢弃未混淆的包，
+// 探测时使用节点的混淆密码进行握手。
+type ProbeService struct {
+	db                  *gorm.DB
+	notificationService *NotificationService
+}
+
+func NewProbeService(db *gorm.DB, notificationService *NotificationService) *ProbeService {
+	return &ProbeService{
+		db:                  db,
+		notificationService: notificationService,
+	}
+}
+
+// 探测所有非维护中的节点
+func (s *ProbeService) ProbeNodes() error {
+	var nodes []models.Node
+	if err := s.db.Where("status <> ?", 2).Find(&nodes).Error; err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, probeConcurrency)
+	for i := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(node *models.Node) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := s.probeNode(node); err != nil {
+				log.Printf("保存节点探测结果失败，节点ID: %d, 错误: %v", node.ID, err)
+			}
+		}(&nodes[i])
+	}
+	wg.Wait()
+
+	return s.db.Where("probed_at < ?", time.Now().Add(-probeRetention)).Delete(&models.NodeProbe{}).Error
+}
+
+// 探测单个节点，保存结果并在可达性变化时发送告警
+func (s *ProbeService) probeNode(node *models.Node) error {
+	probe := probeAddr(node.Host, node.Port, node.ObfsPassword)
+	probe.NodeID = node.ID
+
+	if err := s.db.Create(probe).Error; err != nil {
+		return err
+	}
+
+	// 首次探测时没有历史结果，不发送告警
+	firstProbe := node.ProbedAt.IsZero()
+	wasReachable := node.Reachable
+
+	err := s.db.Model(node).Updates(map[string]interface{}{
+		"reachable":   probe.Success,
+		"latency":     probe.Latency,
+		"packet_loss": probe.PacketLoss,
+		"probed_at":   probe.ProbedAt,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	if firstProbe && probe.Success || !firstProbe && wasReachable == probe.Success {
+		return nil
+	}
+
+	if probe.Success {
+		return s.notificationService.SendNodeReachableAlert(node, probe)
+	}
+	return s.notificationService.SendNodeUnreachableAlert(node, probe)
+}
+
+// 获取节点探测记录
+func (s *ProbeService) GetNodeProbes(nodeID uint, limit int) ([]models.NodeProbe, error) {
+	var probes []models.NodeProbe
+	err := s.db.Where("node_id = ?", nodeID).
+		Order("probed_at DESC").
+		Limit(limit).
+		Find(&probes).Error
+	return probes, err
+}
+
+// 对指定地址进行多次 QUIC 握手，统计平均延迟和失败比例，obfsPassword 不为空时使用 Salamander 混淆
+func probeAddr(host string, port int, obfsPassword string) *models.NodeProbe {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{
+		ServerName: host,
+		NextProtos: []string{"h3"},
+	}
+
+	probe := &models.NodeProbe{ProbedAt: time.Now()}
+	var total time.Duration
+	var succeeded int
+	for i := 0; i < probeAttempts; i++ {
+		latency, err := handshake(addr, tlsConfig, obfsPassword)
+		if err != nil {
+			probe.Error = err.Error()
+			continue
+		}
+		total += latency
+		succeeded++
+	}
+
+	probe.Success = succeeded > 0
+	probe.PacketLoss = float64(probeAttempts-succeeded) / probeAttempts * 100
+	if succeeded > 0 {
+		probe.Latency = (total / time.Duration(succeeded)).Milliseconds()
+	}
+	// 按字符截断，避免截断多字节字符导致写入失败
+	if runes := []rune(probe.Error); len(runes) > 255 {
+		probe.Error = string(runes[:255])
+	}
+
+	return probe
+}
+
+// 完成一次 QUIC 握手（包含 TLS 证书校验）并返回耗时
+func handshake(addr string, tlsConfig *tls.Config, obfsPassword string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer udpConn.Close()
+	var packetConn net.PacketConn = udpConn
+	if obfsPassword != "" {
+		packetConn = newSalamanderConn(udpConn, obfsPassword)
+	}
+	transport := &quic.Transport{Conn: packetConn}
+	defer transport.Close()
+
+	start := time.Now()
+	conn, err := transport.Dial(ctx, udpAddr, tlsConfig, &quic.Config{HandshakeIdleTimeout: probeTimeout})
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	conn.CloseWithError(0, "")
+
+	return latency, nil
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hysteria2-panel/models"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 分流规则类型
+const (
+	RoutingRuleDomain        = "domain"
+	RoutingRuleDomainSuffix  = "domain_suffix"
+	RoutingRuleDomainKeyword = "domain_keyword"
+	RoutingRuleIPCIDR        = "ip_cidr"
+	RoutingRuleGeoIP         = "geoip"
+	RoutingRuleGeoSite       = "geosite"
+	RoutingRuleFinal         = "final"
+)
+
+// 规则内置出口
+const (
+	RoutingOutboundProxy  = "proxy"
+	RoutingOutboundDirect = "direct"
+	RoutingOutboundReject = "reject"
+)
+
+// sing-box 远程规则集地址
+const (
+	singBoxGeoIPURL   = "https://raw.githubusercontent.com/SagerNet/sing-geoip/rule-set/geoip-%s.srs"
+	singBoxGeoSiteURL = "https://raw.githubusercontent.com/SagerNet/sing-geosite/rule-set/geosite-%s.srs"
+)
+
+// 自动测速代理组使用的测速地址和间隔
+const (
+	urlTestURL      = "http://www.gstatic.com/generate_204"
+	urlTestInterval = 300
+)
+
+var (
+	routingNamePattern  = regexp.MustCompile(`^[A-Za-z0-9_\-\p{Han}]{1,50}$`)
+	routingValuePattern = regexp.MustCompile(`^[A-Za-z0-9.\-_:!@]+$`)
+)
+
+// 校验分流规则模板配置
+func validateRoutingConfig(config *models.RoutingConfig) error {
+	names := make(map[string]bool, len(config.Templates))
+	for i := range config.Templates {
+		template := &config.Templates[i]
+		if !routingNamePattern.MatchString(template.Name) {
+			return fmt.Errorf("无效的模板名称: %s", template.Name)
+		}
+		if names[template.Name] {
+			return fmt.Errorf("模板名称重复: %s", template.Name)
+		}
+		names[template.Name] = true
+
+		if err := validateRoutingTemplate(template); err != nil {
+			return fmt.Errorf("模板 %s: %v", template.Name, err)
+		}
+	}
+
+	if config.DefaultTemplate != "" && !names[config.DefaultTemplate] {
+		return fmt.Errorf("默认模板不存在: %s", config.DefaultTemplate)
+	}
+	return nil
+}
+
+func validateRoutingTemplate(template *models.RoutingTemplate) error {
+	outbounds := map[string]bool{
+		RoutingOutboundProxy:  true,
+		RoutingOutboundDirect: true,
+		RoutingOutboundReject: true,
+	}
+	for _, group := range template.Groups {
+		if !routingNamePattern.MatchString(group.Name) {
+			return fmt.Errorf("无效的代理组名称: %s", group.Name)
+		}
+		if outbounds[strings.ToLower(group.Name)] {
+			return fmt.Errorf("代理组名称重复或与内置出口冲突: %s", group.Name)
+		}
+		outbounds[strings.ToLower(group.Name)] = true
+
+		switch group.Type {
+		case "select", "url-test":
+		default:
+			return fmt.Errorf("不支持的代理组类型: %s", group.Type)
+		}
+		if _, err := regexp.Compile(group.Filter); err != nil {
+			return fmt.Errorf("代理组 %s 的节点过滤规则无效: %v", group.Name, err)
+		}
+	}
+
+	for i, rule := range template.Rules {
+		if !outbounds[strings.ToLower(rule.Outbound)] {
+			return fmt.Errorf("第%d条规则的出口不存在: %s", i+1, rule.Outbound)
+		}
+
+		switch rule.Type {
+		case RoutingRuleFinal:
+			if i != len(template.Rules)-1 {
+				return errors.New("final 规则必须是最后一条")
+			}
+			continue
+		case RoutingRuleIPCIDR:
+			if _, _, err := net.ParseCIDR(rule.Value); err != nil {
+				return fmt.Errorf("第%d条规则的IP段无效: %s", i+1, rule.Value)
+			}
+		case RoutingRuleDomain, RoutingRuleDomainSuffix, RoutingRuleDomainKeyword, RoutingRuleGeoIP, RoutingRuleGeoSite:
+			if !routingValuePattern.MatchString(rule.Value) {
+				return fmt.Errorf("第%d条规则的匹配值无效: %s", i+1, rule.Value)
+			}
+		default:
+			return fmt.Errorf("第%d条规则的类型不支持: %s", i+1, rule.Type)
+		}
+	}
+	return nil
+}
+
+// 确定用户使用的分流模板：用户指定 > 当前套餐指定 > 默认模板，都未设置时返回 nil。
+// 模板被删除后对应的设置视为未设置
+func (s *SubscriptionService) userRoutingTemplate(userID uint, userConfig *models.UserConfig) (*models.RoutingTemplate, error) {
+	config, err := s.settingService.GetRoutingConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Templates) == 0 {
+		return nil, nil
+	}
+
+	candidates := []string{userConfig.RoutingTemplate}
+
+	var subscription models.Subscription
+	err = s.db.Preload("Plan").
+		Where("user_id = ? AND status = 1 AND end_at > ?", userID, time.Now()).
+		Order("end_at DESC").
+		First(&subscription).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		candidates = append(candidates, subscription.Plan.RoutingTemplate)
+	}
+	candidates = append(candidates, config.DefaultTemplate)
+
+	for _, name := range candidates {
+		if name == "" {
+			continue
+		}
+		if template := findRoutingTemplate(config, name); template != nil {
+			return template, nil
+		}
+	}
+	return nil, nil
+}
+
+func findRoutingTemplate(config *models.RoutingConfig, name string) *models.RoutingTemplate {
+	for i := range config.Templates {
+		if config.Templates[i].Name == name {
+			return &config.Templates[i]
+		}
+	}
+	return nil
+}
+
+// 按代理组的名称过滤规则筛选节点，没有匹配的节点时返回 nil。
+// 过滤规则保存时已校验，无法编译时（如直接修改了数据库）同样视为没有匹配的节点
+func routingGroupMembers(group *models.RoutingGroup, names []string) []string {
+	filter, err := regexp.Compile(group.Filter)
+	if err != nil {
+		return nil
+	}
+	var members []string
+	for _, name := range names {
+		if filter.MatchString(name) {
+			members = append(members, name)
+		}
+	}
+	return members
+}
+
+// 生成 Clash 代理组和规则。没有模板时所有流量走 Proxy 组
+func clashRouting(template *models.RoutingTemplate, names []string) ([]clashProxyGroup, []string) {
+	// 没有可用节点时代理组不能为空
+	all := names
+	if len(all) == 0 {
+		all = []string{"DIRECT"}
+	}
+	if template == nil {
+		return []clashProxyGroup{{Name: "Proxy", Type: "select", Proxies: all}}, []string{"MATCH,Proxy"}
+	}
+
+	outbounds := map[string]string{
+		RoutingOutboundProxy:  "Proxy",
+		RoutingOutboundDirect: "DIRECT",
+		RoutingOutboundReject: "REJECT",
+	}
+	groupNames := make([]string, 0, len(template.Groups))
+	groups := make([]clashProxyGroup, 0, len(template.Groups)+1)
+	for i := range template.Groups {
+		group := &template.Groups[i]
+		members := routingGroupMembers(group, names)
+		if len(members) == 0 {
+			members = []string{"DIRECT"}
+		}
+		clashGroup := clashProxyGroup{Name: group.Name, Type: group.Type, Proxies: members}
+		if group.Type == "url-test" {
+			clashGroup.URL = urlTestURL
+			clashGroup.Interval = urlTestInterval
+		}
+		groups = append(groups, clashGroup)
+		groupNames = append(groupNames, group.Name)
+		outbounds[strings.ToLower(group.Name)] = group.Name
+	}
+	// 自定义代理组可以在主代理组中选择
+	groups = append([]clashProxyGroup{{Name: "Proxy", Type: "select", Proxies: append(groupNames, all...)}}, groups...)
+
+	rules := make([]string, 0, len(template.Rules)+1)
+	hasFinal := false
+	for _, rule := range template.Rules {
+		outbound := outbounds[strings.ToLower(rule.Outbound)]
+		switch rule.Type {
+		case RoutingRuleDomain:
+			rules = append(rules, fmt.Sprintf("DOMAIN,%s,%s", rule.Value, outbound))
+		case RoutingRuleDomainSuffix:
+			rules = append(rules, fmt.Sprintf("DOMAIN-SUFFIX,%s,%s", rule.Value, outbound))
+		case RoutingRuleDomainKeyword:
+			rules = append(rules, fmt.Sprintf("DOMAIN-KEYWORD,%s,%s", rule.Value, outbound))
+		case RoutingRuleIPCIDR:
+			if strings.Contains(rule.Value, ":") {
+				rules = append(rules, fmt.Sprintf("IP-CIDR6,%s,%s,no-resolve", rule.Value, outbound))
+			} else {
+				rules = append(rules, fmt.Sprintf("IP-CIDR,%s,%s,no-resolve", rule.Value, outbound))
+			}
+		case RoutingRuleGeoIP:
+			rules = append(rules, fmt.Sprintf("GEOIP,%s,%s", strings.ToUpper(rule.Value), outbound))
+		case RoutingRuleGeoSite:
+			rules = append(rules, fmt.Sprintf("GEOSITE,%s,%s", strings.ToLower(rule.Value), outbound))
+		case RoutingRuleFinal:
+			rules = append(rules, "MATCH,"+outbound)
+			hasFinal = true
+		}
+	}
+	if !hasFinal {
+		rules = append(rules, "MATCH,Proxy")
+	}
+	return groups, rules
+}
+
+// 生成 sing-box 代理组出站和路由配置。geoip/geosite 规则使用远程规则集
+func singBoxRouting(template *models.RoutingTemplate, tags []string) ([]map[string]interface{}, map[string]interface{}) {
+	all := tags
+	if len(all) == 0 {
+		all = []string{"direct"}
+	}
+	if template == nil {
+		return []map[string]interface{}{
+			{"type": "selector", "tag": "proxy", "outbounds": all},
+		}, map[string]interface{}{"final": "proxy"}
+	}
+
+	outbounds := map[string]string{
+		RoutingOutboundProxy:  "proxy",
+		RoutingOutboundDirect: "direct",
+		RoutingOutboundReject: "block",
+	}
+	groupTags := make([]string, 0, len(template.Groups))
+	groups := make([]map[string]interface{}, 0, len(template.Groups)+1)
+	for i := range template.Groups {
+		group := &template.Groups[i]
+		members := routingGroupMembers(group, tags)
+		if len(members) == 0 {
+			members = []string{"direct"}
+		}
+		outbound := map[string]interface{}{"type": "selector", "tag": group.Name, "outbounds": members}
+		if group.Type == "url-test" {
+			outbound["type"] = "urltest"
+			outbound["url"] = urlTestURL
+			outbound["interval"] = fmt.Sprintf("%ds", urlTestInterval)
+		}
+		groups = append(groups, outbound)
+		groupTags = append(groupTags, group.Name)
+		outbounds[strings.ToLower(group.Name)] = group.Name
+	}
+	groups = append([]map[string]interface{}{
+		{"type": "selector", "tag": "proxy", "outbounds": append(groupTags, all...)},
+	}, groups...)
+
+	final := "proxy"
+	var rules []map[string]interface{}
+	var ruleSets []map[string]interface{}
+	ruleSetAdded := make(map[string]bool)
+	addRuleSet := func(tag, url string) {
+		if ruleSetAdded[tag] {
+			return
+		}
+		ruleSetAdded[tag] = true
+		ruleSets = append(ruleSets, map[string]interface{}{
+			"type":            "remote",
+			"tag":             tag,
+			"format":          "binary",
+			"url":             url,
+			"download_detour": "direct",
+		})
+	}
+
+	for _, rule := range template.Rules {
+		outbound := outbounds[strings.ToLower(rule.Outbound)]
+		var match map[string]interface{}
+		switch rule.Type {
+		case RoutingRuleDomain:
+			match = map[string]interface{}{"domain": []string{rule.Value}}
+		case RoutingRuleDomainSuffix:
+			match = map[string]interface{}{"domain_suffix": []string{rule.Value}}
+		case RoutingRuleDomainKeyword:
+			match = map[string]interface{}{"domain_keyword": []string{rule.Value}}
+		case RoutingRuleIPCIDR:
+			match = map[string]interface{}{"ip_cidr": []string{rule.Value}}
+		case RoutingRuleGeoIP:
+			tag := "geoip-" + strings.ToLower(rule.Value)
+			addRuleSet(tag, fmt.Sprintf(singBoxGeoIPURL, strings.ToLower(rule.Value)))
+			match = map[string]interface{}{"rule_set": []string{tag}}
+		case RoutingRuleGeoSite:
+			tag := "geosite-" + strings.ToLower(rule.Value)
+			addRuleSet(tag, fmt.Sprintf(singBoxGeoSiteURL, strings.ToLower(rule.Value)))
+			match = map[string]interface{}{"rule_set": []string{tag}}
+		case RoutingRuleFinal:
+			final = outbound
+			continue
+		}
+		match["outbound"] = outbound
+		rules = append(rules, match)
+	}
+
+	route := map[string]interface{}{
+		"final":                 final,
+		"auto_detect_interface": true,
+	}
+	if len(rules) > 0 {
+		route["rules"] = rules
+	}
+	if len(ruleSets) > 0 {
+		route["rule_set"] = ruleSets
+	}
+	return groups, route
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"hysteria2-panel/models"
+)
+
+func TestRoutingGroupMembers(t *testing.T) {
+	names := []string{"香港 01", "香港 02", "日本 01", "US 01"}
+	tests := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{"所有节点", "", names},
+		{"按地区筛选", "香港|日本", []string{"香港 01", "香港 02", "日本 01"}},
+		{"没有匹配的节点", "新加坡", nil},
+		{"无效的过滤规则", "香港(", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := routingGroupMembers(&models.RoutingGroup{Name: "g", Filter: tt.filter}, names)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("routingGroupMembers(%q) = %q, want %q", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+// 测试用的分流模板：香港节点自动测速，流媒体走香港组，国内直连
+func testRoutingTemplate() *models.RoutingTemplate {
+	return &models.RoutingTemplate{
+		Name: "default",
+		Groups: []models.RoutingGroup{
+			{Name: "HK", Type: "url-test", Filter: "香港"},
+			{Name: "SG", Type: "select", Filter: "新加坡"},
+		},
+		Rules: []models.RoutingRule{
+			{Type: RoutingRuleGeoSite, Value: "Netflix", Outbound: "hk"},
+			{Type: RoutingRuleDomainSuffix, Value: "example.cn", Outbound: RoutingOutboundDirect},
+			{Type: RoutingRuleIPCIDR, Value: "2001:db8::/32", Outbound: RoutingOutboundReject},
+			{Type: RoutingRuleGeoIP, Value: "cn", Outbound: RoutingOutboundDirect},
+			{Type: RoutingRuleFinal, Outbound: RoutingOutboundProxy},
+		},
+	}
+}
+
+func TestClashRouting(t *testing.T) {
+	names := []string{"香港 01", "日本 01"}
+
+	t.Run("没有模板", func(t *testing.T) {
+		groups, rules := clashRouting(nil, names)
+		want := []clashProxyGroup{{Name: "Proxy", Type: "select", Proxies: names}}
+		if !reflect.DeepEqual(groups, want) || !reflect.DeepEqual(rules, []string{"MATCH,Proxy"}) {
+			t.Errorf("clashRouting(nil) = %+v, %v", groups, rules)
+		}
+	})
+
+	t.Run("没有可用节点", func(t *testing.T) {
+		groups, _ := clashRouting(nil, nil)
+		if !reflect.DeepEqual(groups[0].Proxies, []string{"DIRECT"}) {
+			t.Errorf("代理组不能为空: %+v", groups)
+		}
+	})
+
+	t.Run("模板", func(t *testing.T) {
+		groups, rules := clashRouting(testRoutingTemplate(), names)
+		wantGroups := []clashProxyGroup{
+			{Name: "Proxy", Type: "select", Proxies: []string{"HK", "SG", "香港 01", "日本 01"}},
+			{Name: "HK", Type: "url-test", Proxies: []string{"香港 01"}, URL: urlTestURL, Interval: urlTestInterval},
+			{Name: "SG", Type: "select", Proxies: []string{"DIRECT"}},
+		}
+		if !reflect.DeepEqual(groups, wantGroups) {
+			t.Errorf("groups = %+v, want %+v", groups, wantGroups)
+		}
+		wantRules := []string{
+			"GEOSITE,netflix,HK",
+			"DOMAIN-SUFFIX,example.cn,DIRECT",
+			"IP-CIDR6,2001:db8::/32,REJECT,no-resolve",
+			"GEOIP,CN,DIRECT",
+			"MATCH,Proxy",
+		}
+		if !reflect.DeepEqual(rules, wantRules) {
+			t.Errorf("rules = %q, want %q", rules, wantRules)
+		}
+	})
+}
+
+func TestSingBoxRouting(t *testing.T) {
+	tags := []string{"香港 01", "日本 01"}
+
+	t.Run("没有模板", func(t *testing.T) {
+		groups, route := singBoxRouting(nil, tags)
+		if len(groups) != 1 || groups[0]["tag"] != "proxy" || !reflect.DeepEqual(groups[0]["outbounds"], tags) {
+			t.Errorf("groups = %v", groups)
+		}
+		if route["final"] != "proxy" {
+			t.Errorf("route = %v", route)
+		}
+	})
+
+	t.Run("模板", func(t *testing.T) {
+		groups, route := singBoxRouting(testRoutingTemplate(), tags)
+		wantGroups := []map[string]interface{}{
+			{"type": "selector", "tag": "proxy", "outbounds": []string{"HK", "SG", "香港 01", "日本 01"}},
+			{"type": "urltest", "tag": "HK", "outbounds": []string{"香港 01"}, "url": urlTestURL, "interval": "300s"},
+			{"type": "selector", "tag": "SG", "outbounds": []string{"direct"}},
+		}
+		if !reflect.DeepEqual(groups, wantGroups) {
+			t.Errorf("groups = %v, want %v", groups, wantGroups)
+		}
+
+		wantRules := []map[string]interface{}{
+			{"rule_set": []string{"geosite-netflix"}, "outbound": "HK"},
+			{"domain_suffix": []string{"example.cn"}, "outbound": "direct"},
+			{"ip_cidr": []string{"2001:db8::/32"}, "outbound": "block"},
+			{"rule_set": []string{"geoip-cn"}, "outbound": "direct"},
+		}
+		if !reflect.DeepEqual(route["rules"], wantRules) {
+			t.Errorf("rules = %v, want %v", route["rules"], wantRules)
+		}
+		if route["final"] != "proxy" {
+			t.Errorf("final = %v", route["final"])
+		}
+		ruleSets, _ := route["rule_set"].([]map[string]interface{})
+		if len(ruleSets) != 2 || ruleSets[0]["tag"] != "geosite-netflix" || ruleSets[1]["tag"] != "geoip-cn" {
+			t.Errorf("rule_set = %v", route["rule_set"])
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"crypto/rand"
+	"net"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Salamander 混淆：每个 UDP 包前加 8 字节随机盐，负载与 BLAKE2b-256(密码+盐) 循环异或
+const salamanderSaltLen = 8
+
+// salamanderConn 对 UDP 包进行 Salamander 混淆，用于向启用了混淆的节点发起 QUIC 握手
+type salamanderConn struct {
+	net.PacketConn
+	password []byte
+}
+
+func newSalamanderConn(conn net.PacketConn, password string) *salamanderConn {
+	return &salamanderConn{PacketConn: conn, password: []byte(password)}
+}
+
+func (c *salamanderConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(p)+salamanderSaltLen)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, addr, err
+		}
+		// 丢弃无法解混淆的包
+		if n <= salamanderSaltLen {
+			continue
+		}
+		c.xor(p, buf[salamanderSaltLen:n], buf[:salamanderSaltLen])
+		return n - salamanderSaltLen, addr, nil
+	}
+}
+
+func (c *salamanderConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	buf := make([]byte, len(p)+salamanderSaltLen)
+	if _, err := rand.Read(buf[:salamanderSaltLen]); err != nil {
+		return 0, err
+	}
+	c.xor(buf[salamanderSaltLen:], p, buf[:salamanderSaltLen])
+	if _, err := c.PacketConn.WriteTo(buf, addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// 将 src 与密钥流异或后写入 dst
+func (c *salamanderConn) xor(dst, src, salt []byte) {
+	key := blake2b.Sum256(append(append([]byte{}, c.password...), salt...))
+	for i := range src {
+		dst[i] = src[i] ^ key[i%blake2b.Size256]
+	}
+}
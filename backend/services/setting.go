@@ -3,7 +3,10 @@ package services
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"hysteria2-panel/models"
+	"net/mail"
+	"net/url"
 
 	"gorm.io/gorm"
 )
@@ -97,3 +100,213 @@ func (s *SettingService) GetAnnouncement() (string, error) {
 func (s *SettingService) UpdateAnnouncement(content string) error {
 	return s.UpdateSetting(models.SettingKeyAnnouncement, content)
 }
+
+// 获取订单配置，未设置时返回默认值
+func (s *SettingService) GetOrderConfig() (*models.OrderConfig, error) {
+	config := models.OrderConfig{ExpireMinutes: 30}
+
+	setting, err := s.GetSetting(models.SettingKeyOrder)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &config, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(setting.Value), &config); err != nil {
+		return nil, err
+	}
+	if config.ExpireMinutes <= 0 {
+		config.ExpireMinutes = 30
+	}
+
+	return &config, nil
+}
+
+// 更新订单配置
+func (s *SettingService) UpdateOrderConfig(config *models.OrderConfig) error {
+	if config.ExpireMinutes <= 0 {
+		return errors.New("订单过期时间必须大于0")
+	}
+	return s.UpdateSetting(models.SettingKeyOrder, config)
+}
+
+// 获取用户配置自动分配设置
+func (s *SettingService) GetProvisionConfig() (*models.ProvisionConfig, error) {
+	config := models.ProvisionConfig{PortStart: 20000, PortEnd: 29999}
+
+	setting, err := s.GetSetting(models.SettingKeyProvision)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &config, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(setting.Value), &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// 更新用户配置自动分配设置
+func (s *SettingService) UpdateProvisionConfig(config *models.ProvisionConfig) error {
+	if config.PortStart < 1 || config.PortEnd > 65535 || config.PortStart > config.PortEnd {
+		return errors.New("无效的端口池范围")
+	}
+
+	// 用户端口上的实例与节点的共用端口运行在同一台机器上，端口池不能与节点监听或端口跳跃的端口重叠
+	var nodes []models.Node
+	if err := s.db.Select("name, port, hop_ports").Find(&nodes).Error; err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		ranges := [][2]int{{node.Port, node.Port}}
+		if node.HopPorts != "" {
+			if hops, err := parseHopPorts(node.HopPorts); err == nil {
+				ranges = append(ranges, hops...)
+			}
+		}
+		for _, r := range ranges {
+			if r[0] <= config.PortEnd && r[1] >= config.PortStart {
+				return fmt.Errorf("端口池与节点 %s 的端口 %d-%d 重叠", node.Name, r[0], r[1])
+			}
+		}
+	}
+	return s.UpdateSetting(models.SettingKeyProvision, config)
+}
+
+// 获取发票配置
+func (s *SettingService) GetInvoiceConfig() (*models.InvoiceConfig, error) {
+	config := models.InvoiceConfig{Prefix: "INV"}
+
+	setting, err := s.GetSetting(models.SettingKeyInvoice)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &config, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(setting.Value), &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// 更新发票配置
+func (s *SettingService) UpdateInvoiceConfig(config *models.InvoiceConfig) error {
+	if config.TaxRate < 0 || config.TaxRate >= 100 {
+		return errors.New("税率必须在0到100之间")
+	}
+	return s.UpdateSetting(models.SettingKeyInvoice, config)
+}
+
+// 获取币种与汇率配置
+func (s *SettingService) GetCurrencyConfig() (*models.CurrencyConfig, error) {
+	config := models.CurrencyConfig{
+		DefaultCurrency: models.CurrencyCNY,
+		RateAPI:         "https://open.er-api.com/v6/latest/{base}",
+	}
+
+	setting, err := s.GetSetting(models.SettingKeyCurrency)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &config, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(setting.Value), &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// 更新币种与汇率配置
+func (s *SettingService) UpdateCurrencyConfig(config *models.CurrencyConfig) error {
+	if !models.IsSupportedCurrency(config.DefaultCurrency) {
+		return errors.New("不支持的币种")
+	}
+	return s.UpdateSetting(models.SettingKeyCurrency, config)
+}
+
+// 获取告警配置
+func (s *SettingService) GetAlertConfig() (*models.AlertConfig, error) {
+	var config models.AlertConfig
+
+	setting, err := s.GetSetting(models.SettingKeyAlert)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &config, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(setting.Value), &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// 更新告警配置
+func (s *SettingService) UpdateAlertConfig(config *models.AlertConfig) error {
+	for _, email := range config.Emails {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fmt.Errorf("无效的告警邮箱: %s", email)
+		}
+	}
+	if config.WebhookURL != "" {
+		u, err := url.Parse(config.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("无效的告警Webhook地址")
+		}
+	}
+	return s.UpdateSetting(models.SettingKeyAlert, config)
+}
+
+// 获取分流规则模板配置
+func (s *SettingService) GetRoutingConfig() (*models.RoutingConfig, error) {
+	var config models.RoutingConfig
+
+	setting, err := s.GetSetting(models.SettingKeyRouting)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &config, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(setting.Value), &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// 更新分流规则模板配置
+func (s *SettingService) UpdateRoutingConfig(config *models.RoutingConfig) error {
+	if err := validateRoutingConfig(config); err != nil {
+		return err
+	}
+	return s.UpdateSetting(models.SettingKeyRouting, config)
+}
+
+// 检查分流模板是否存在，名称为空表示使用上一级的模板
+func (s *SettingService) CheckRoutingTemplate(name string) error {
+	if name == "" {
+		return nil
+	}
+	config, err := s.GetRoutingConfig()
+	if err != nil {
+		return err
+	}
+	if findRoutingTemplate(config, name) == nil {
+		return fmt.Errorf("分流模板不存在: %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// 生成 hysteria2:// 分享链接，格式参考 https://v2.hysteria.network/docs/developers/URI-Scheme/
+//
+//	hysteria2://[auth@]hostname[:port]/?[key=value]&[key=value]...#name
+//
+// 端口可以是端口跳跃的多端口形式，userpass 认证的 "用户:密码" 放在 userinfo 中
+func ShareURI(name string, config *ClientConfig) string {
+	u := &url.URL{
+		Scheme: "hysteria2",
+		Host:   config.Server,
+		Path:   "/",
+	}
+	if user, password, ok := strings.Cut(config.Auth, ":"); ok {
+		u.User = url.UserPassword(user, password)
+	} else {
+		u.User = url.User(config.Auth)
+	}
+
+	query := url.Values{}
+	if config.Obfs != nil && config.Obfs.Salamander != nil {
+		query.Set("obfs", config.Obfs.Type)
+		query.Set("obfs-password", config.Obfs.Salamander.Password)
+	}
+	if config.TLS != nil {
+		if config.TLS.SNI != "" {
+			query.Set("sni", config.TLS.SNI)
+		}
+		if config.TLS.Insecure {
+			query.Set("insecure", "1")
+		}
+		if config.TLS.PinSHA256 != "" {
+			query.Set("pinSHA256", config.TLS.PinSHA256)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.Fragment = name
+
+	return u.String()
+}
+
+// 解析 hysteria2:// 或 hy2:// 分享链接，返回客户端配置和链接中的名称
+func ParseShareURI(uri string) (*ClientConfig, string, error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(uri), "://")
+	if !ok {
+		return nil, "", errors.New("无效的分享链接")
+	}
+	if scheme != "hysteria2" && scheme != "hy2" {
+		return nil, "", fmt.Errorf("不支持的链接协议: %s", scheme)
+	}
+
+	// 多端口形式（如 host:20000-50000）不是合法的 URL 端口，先取出 host 部分再解析其余内容
+	authority := rest
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		authority = rest[:i]
+	}
+	hostport := authority
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		hostport = authority[i+1:]
+	}
+
+	u, err := url.Parse(scheme + "://" + strings.TrimSuffix(authority, hostport) + "placeholder" + rest[len(authority):])
+	if err != nil {
+		return nil, "", errors.New("无效的分享链接")
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// 未指定端口时默认为443，IPv6 地址必须使用方括号，否则无法区分地址和端口
+		switch {
+		case strings.HasPrefix(hostport, "[") && strings.HasSuffix(hostport, "]"):
+			host = hostport[1 : len(hostport)-1]
+		case strings.Contains(hostport, ":"):
+			return nil, "", fmt.Errorf("无效的服务器地址: %s", hostport)
+		default:
+			host = hostport
+		}
+		port = "443"
+	}
+	if host == "" {
+		return nil, "", errors.New("分享链接缺少服务器地址")
+	}
+	if _, err := parseHopPorts(port); err != nil {
+		return nil, "", err
+	}
+	config := &ClientConfig{Server: net.JoinHostPort(host, port)}
+
+	if u.User != nil {
+		config.Auth = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			config.Auth += ":" + password
+		}
+	}
+
+	query := u.Query()
+	if obfs := query.Get("obfs"); obfs != "" {
+		config.Obfs = &ObfsConfig{
+			Type:       obfs,
+			Salamander: &SalamanderConfig{Password: query.Get("obfs-password")},
+		}
+	}
+
+	tls := &ClientTLS{
+		SNI:       query.Get("sni"),
+		Insecure:  query.Get("insecure") == "1",
+		PinSHA256: query.Get("pinSHA256"),
+	}
+	if *tls != (ClientTLS{}) {
+		config.TLS = tls
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, "", err
+	}
+	return config, u.Fragment, nil
+}
+
+// 生成分享链接的二维码，format 可选 png（默认）或 svg
+func ShareQRCode(uri, format string, size int) ([]byte, error) {
+	qr, err := qrcode.New(uri, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "", "png":
+		return qr.PNG(size)
+	case "svg":
+		return qrSVG(qr.Bitmap(), size), nil
+	default:
+		return nil, fmt.Errorf("不支持的二维码格式: %s", format)
+	}
+}
+
+// 将二维码点阵渲染为 SVG，相邻的黑色模块合并为一个矩形以减小体积
+func qrSVG(bitmap [][]bool, size int) []byte {
+	var buf bytes.Buffer
+	n := len(bitmap)
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, n, n)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`, n, n)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x+1 < len(row) && row[x+1] {
+				x++
+			}
+			fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="1"/>`, start, y, x-start+1)
+		}
+	}
+	buf.WriteString(`</svg>`)
+	return buf.Bytes()
+}
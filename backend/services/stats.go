@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"hysteria2-panel/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type StatsService struct {
+	db *gorm.DB
+}
+
+func NewStatsService(db *gorm.DB) *StatsService {
+	return &StatsService{db: db}
+}
+
+// 收入统计行，不同币种分别汇总
+type RevenueStat struct {
+	Key      string `json:"key"`
+	Currency string `json:"currency"`
+	Orders   int64  `json:"orders"`
+	Amount   int64  `json:"amount"` // 最小货币单位
+}
+
+// 新老客户统计行
+type CustomerStat struct {
+	Period    string `json:"period"`
+	New       int64  `json:"new"`       // 首次付费在本周期的用户数
+	Renewing  int64  `json:"renewing"`  // 首次付费在之前周期、本周期再次付费的用户数
+	Customers int64  `json:"customers"` // 付费用户总数，等于新客户与续费客户之和
+}
+
+// 流失统计行
+type ChurnStat struct {
+	Period    string  `json:"period"`
+	Expired   int64   `json:"expired"`    // 到期订阅数
+	Renewed   int64   `json:"renewed"`    // 到期前或到期后宽限期内续订的订阅数
+	Churned   int64   `json:"churned"`    // 到期未续订的订阅数
+	ChurnRate float64 `json:"churn_rate"` // 流失率（百分比）
+}
+
+// ARPU统计行
+type ARPUStat struct {
+	Currency string  `json:"currency"`
+	Revenue  int64   `json:"revenue"` // 最小货币单位
+	Payers   int64   `json:"payers"`  // 付费用户数
+	ARPU     float64 `json:"arpu"`    // 每付费用户平均收入（最小货币单位）
+}
+
+// 活跃订阅数统计点
+type ActiveSubscriberStat struct {
+	Date   string `json:"date"`
+	Active int64  `json:"active"`
+}
+
+// 时间分组对应的日期格式
+func periodFormat(group string) (string, error) {
+	switch group {
+	case "day":
+		return "%Y-%m-%d", nil
+	case "month":
+		return "%Y-%m", nil
+	default:
+		return "", errors.New("不支持的分组方式")
+	}
+}
+
+// 按日、月、套餐或支付方式统计收入
+func (s *StatsService) GetRevenue(group string, start, end time.Time) ([]RevenueStat, error) {
+	var key string
+	switch group {
+	case "day", "month":
+		format, _ := periodFormat(group)
+		key = "DATE_FORMAT(orders.pay_at, '" + format + "')"
+	case "plan":
+		key = "COALESCE(plans.name, CAST(orders.plan_id AS CHAR))"
+	case "method":
+		key = "orders.payment_method"
+	default:
+		return nil, errors.New("不支持的分组方式")
+	}
+
+	var stats []RevenueStat
+	err := s.db.Model(&models.Order{}).
+		Select(key+" AS `key`, orders.currency AS currency, COUNT(*) AS orders, SUM(orders.amount) AS amount").
+		Joins("LEFT JOIN plans ON plans.id = orders.plan_id").
+		Where("orders.payment_status = ? AND orders.pay_at >= ? AND orders.pay_at < ?", 1, start, end).
+		Group("`key`, orders.currency").
+		Order("`key`, orders.currency").
+		Scan(&stats).Error
+	return stats, err
+}
+
+// 统计新客户与续费客户。用户首次付费所在的周期计为新客户，之后的周期计为续费客户，
+// 同一周期内两者互斥
+func (s *StatsService) GetCustomers(group string, start, end time.Time) ([]CustomerStat, error) {
+	if _, err := periodFormat(group); err != nil {
+		return nil, err
+	}
+
+	firstPay := s.db.Model(&models.Order{}).
+		Select("user_id, MIN(pay_at) AS first_pay_at").
+		Where("payment_status = ?", 1).
+		Group("user_id")
+
+	var payments []customerPayment
+	err := s.db.Table("orders AS o").
+		Select("o.user_id, o.pay_at, f.first_pay_at").
+		Joins("JOIN (?) AS f ON f.user_id = o.user_id", firstPay).
+		Where("o.payment_status = ? AND o.pay_at >= ? AND o.pay_at < ?", 1, start, end).
+		Scan(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return countCustomers(payments, group), nil
+}
+
+// 统计范围内的一次付费，以及该用户的首次付费时间
+type customerPayment struct {
+	UserID     uint
+	PayAt      time.Time
+	FirstPayAt time.Time
+}
+
+// 按周期汇总付费记录，同一用户在一个周期内只计一次
+func countCustomers(payments []customerPayment, group string) []CustomerStat {
+	layout := "2006-01"
+	if group == "day" {
+		layout = "2006-01-02"
+	}
+
+	type periodUsers struct {
+		new, renewing map[uint]bool
+	}
+	periods := make(map[string]*periodUsers)
+	for _, p := range payments {
+		period := p.PayAt.Format(layout)
+		users := periods[period]
+		if users == nil {
+			users = &periodUsers{new: make(map[uint]bool), renewing: make(map[uint]bool)}
+			periods[period] = users
+		}
+		if p.FirstPayAt.Format(layout) == period {
+			users.new[p.UserID] = true
+		} else {
+			users.renewing[p.UserID] = true
+		}
+	}
+
+	stats := make([]CustomerStat, 0, len(periods))
+	for period, users := range periods {
+		stats = append(stats, CustomerStat{
+			Period:    period,
+			New:       int64(len(users.new)),
+			Renewing:  int64(len(users.renewing)),
+			Customers: int64(len(users.new) + len(users.renewing)),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Period < stats[j].Period })
+	return stats
+}
+
+// 订阅到期后仍视为续订的宽限天数
+const renewalGraceDays = 7
+
+// 统计到期未续订的订阅（流失）。订阅开始之后、到期后宽限期之前有新订阅开始的视为续订
+func (s *StatsService) GetChurn(group string, start, end time.Time) ([]ChurnStat, error) {
+	format, err := periodFormat(group)
+	if err != nil {
+		return nil, err
+	}
+
+	// 只统计已经到期的订阅
+	if end.After(time.Now()) {
+		end = time.Now()
+	}
+
+	var stats []ChurnStat
+	err = s.db.Table("subscriptions AS s").
+		Select("DATE_FORMAT(s.end_at, ?) AS period, COUNT(*) AS expired, "+
+			"SUM(CASE WHEN EXISTS (SELECT 1 FROM subscriptions AS n WHERE n.user_id = s.user_id AND n.id <> s.id "+
+			"AND n.start_at > s.start_at AND n.start_at <= DATE_ADD(s.end_at, INTERVAL ? DAY)) THEN 1 ELSE 0 END) AS renewed",
+			format, renewalGraceDays).
+		Where("s.end_at >= ? AND s.end_at < ?", start, end).
+		Group("period").
+		Order("period").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stats {
+		stats[i].Churned = stats[i].Expired - stats[i].Renewed
+		if stats[i].Expired > 0 {
+			stats[i].ChurnRate = float64(stats[i].Churned) / float64(stats[i].Expired) * 100
+		}
+	}
+
+	return stats, nil
+}
+
+// 统计每付费用户平均收入
+func (s *StatsService) GetARPU(start, end time.Time) ([]ARPUStat, error) {
+	var stats []ARPUStat
+	err := s.db.Model(&models.Order{}).
+		Select("currency, SUM(amount) AS revenue, COUNT(DISTINCT user_id) AS payers").
+		Where("payment_status = ? AND pay_at >= ? AND pay_at < ?", 1, start, end).
+		Group("currency").
+		Order("currency").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stats {
+		if stats[i].Payers > 0 {
+			stats[i].ARPU = float64(stats[i].Revenue) / float64(stats[i].Payers)
+		}
+	}
+
+	return stats, nil
+}
+
+// 统计每日结束时处于生效状态的订阅数
+func (s *StatsService) GetActiveSubscribers(start, end time.Time) ([]ActiveSubscriberStat, error) {
+	var subscriptions []models.Subscription
+	err := s.db.Select("start_at, end_at").
+		Where("start_at < ? AND end_at > ?", end, start).
+		Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []ActiveSubscriberStat
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		dayEnd := day.AddDate(0, 0, 1)
+		var active int64
+		for _, sub := range subscriptions {
+			if sub.StartAt.Before(dayEnd) && sub.EndAt.After(dayEnd) {
+				active++
+			}
+		}
+		stats = append(stats, ActiveSubscriberStat{
+			Date:   day.Format("2006-01-02"),
+			Active: active,
+		})
+	}
+
+	return stats, nil
+}
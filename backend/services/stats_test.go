@@ -0,0 +1,72 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCountCustomers(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	payments := []customerPayment{
+		// 用户1首次付费在1月，2月续费
+		{UserID: 1, PayAt: day("2024-01-05"), FirstPayAt: day("2024-01-05")},
+		{UserID: 1, PayAt: day("2024-02-05"), FirstPayAt: day("2024-01-05")},
+		// 用户2在2月首次付费并在同月再次付费，只计为新客户
+		{UserID: 2, PayAt: day("2024-02-01"), FirstPayAt: day("2024-02-01")},
+		{UserID: 2, PayAt: day("2024-02-20"), FirstPayAt: day("2024-02-01")},
+		// 用户3首次付费在统计范围之前，2月两次付费只计一次续费
+		{UserID: 3, PayAt: day("2024-02-03"), FirstPayAt: day("2023-11-11")},
+		{UserID: 3, PayAt: day("2024-02-25"), FirstPayAt: day("2023-11-11")},
+	}
+
+	tests := []struct {
+		name  string
+		group string
+		want  []CustomerStat
+	}{
+		{"按月", "month", []CustomerStat{
+			{Period: "2024-01", New: 1, Renewing: 0, Customers: 1},
+			{Period: "2024-02", New: 1, Renewing: 2, Customers: 3},
+		}},
+		{"按日", "day", []CustomerStat{
+			{Period: "2024-01-05", New: 1, Customers: 1},
+			{Period: "2024-02-01", New: 1, Customers: 1},
+			{Period: "2024-02-03", Renewing: 1, Customers: 1},
+			{Period: "2024-02-05", Renewing: 1, Customers: 1},
+			{Period: "2024-02-20", Renewing: 1, Customers: 1},
+			{Period: "2024-02-25", Renewing: 1, Customers: 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := countCustomers(payments, tt.group)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("countCustomers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountCustomersEmpty(t *testing.T) {
+	if got := countCustomers(nil, "month"); len(got) != 0 {
+		t.Errorf("没有付费记录时应返回空结果: %+v", got)
+	}
+}
+
+func TestGetCustomersRejectsUnknownGroup(t *testing.T) {
+	db, statements := newDryRunDB(t)
+	if _, err := NewStatsService(db).GetCustomers("plan", time.Now(), time.Now()); err == nil {
+		t.Error("不支持的分组方式应返回错误")
+	}
+	if len(*statements) != 0 {
+		t.Errorf("分组方式无效时不应查询数据库: %v", *statements)
+	}
+}
@@ -0,0 +1,553 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hysteria2-panel/models"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// 订阅输出格式
+const (
+	SubTargetLinks     = "links"     // hysteria2:// 分享链接列表（base64）
+	SubTargetClash     = "clash"     // Clash Meta / mihomo YAML
+	SubTargetSingBox   = "singbox"   // sing-box JSON
+	SubTargetHysteria2 = "hysteria2" // Hysteria2 官方客户端 YAML
+)
+
+// 订阅泄露检测：窗口期内拉取同一订阅的不同IP数达到阈值时告警，同一订阅每个窗口期最多告警一次
+const (
+	subLeakWindow     = 24 * time.Hour
+	subLeakThreshold  = 10
+	subFetchRetention = 30 * 24 * time.Hour // 拉取记录保留时间
+)
+
+// 订阅不存在或没有可用内容，与数据库等内部错误区分
+var ErrSubscriptionNotFound = errors.New("订阅不存在")
+
+// 不支持的订阅格式
+var ErrSubscriptionTarget = errors.New("不支持的订阅格式")
+
+// 带具体原因的订阅不存在错误，errors.Is 判断为 ErrSubscriptionNotFound
+type subNotFoundError string
+
+func (e subNotFoundError) Error() string { return string(e) }
+
+func (e subNotFoundError) Is(target error) bool { return target == ErrSubscriptionNotFound }
+
+// SubscriptionService 根据订阅令牌生成用户可用节点的客户端订阅
+type SubscriptionService struct {
+	db                  *gorm.DB
+	nodeService         *NodeService
+	configManager       *ConfigManagerService
+	hy2Service          *Hysteria2Service
+	trafficService      *TrafficService
+	onlineService       *OnlineService
+	notificationService *NotificationService
+	settingService      *SettingService
+	deployService       *DeployService
+}
+
+func NewSubscriptionService(db *gorm.DB, nodeService *NodeService, configManager *ConfigManagerService, hy2Service *Hysteria2Service, trafficService *TrafficService, onlineService *OnlineService, notificationService *NotificationService, settingService *SettingService, deployService *DeployService) *SubscriptionService {
+	return &SubscriptionService{
+		db:                  db,
+		nodeService:         nodeService,
+		configManager:       configManager,
+		hy2Service:          hy2Service,
+		trafficService:      trafficService,
+		onlineService:       onlineService,
+		notificationService: notificationService,
+		settingService:      settingService,
+		deployService:       deployService,
+	}
+}
+
+// 订阅内容
+type SubscriptionContent struct {
+	Body        []byte
+	ContentType string
+	UserInfo    string // Subscription-Userinfo 响应头
+}
+
+// 订阅中的单个节点
+type subscriptionEntry struct {
+	node       *models.Node
+	userConfig *models.UserConfig
+	config     *ClientConfig
+}
+
+// 获取用户的订阅令牌，不存在时自动生成
+func (s *SubscriptionService) GetSubToken(userID uint) (string, error) {
+	var token models.SubscriptionToken
+	err := s.db.Where("user_id = ?", userID).First(&token).Error
+	if err == nil {
+		return token.Token, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	if err := s.db.First(&models.User{}, userID).Error; err != nil {
+		return "", errors.New("用户不存在")
+	}
+
+	value, err := generateSubToken()
+	if err != nil {
+		return "", err
+	}
+	token = models.SubscriptionToken{UserID: userID, Token: value}
+	if err := s.db.Create(&token).Error; err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+// 重置订阅：更换订阅令牌和节点认证密码，旧订阅链接立即失效。
+// 节点上的旧密码在节点更新配置后失效：使用节点代理的节点在下次同步配置时（默认1分钟内）获取新密码，
+// 并在下次上报在线用户时踢出用户的现有连接；通过 SSH 部署的节点立即重新部署。
+// 用户认证信息不属于配置版本，固定了配置版本的节点同样使用新密码
+func (s *SubscriptionService) ResetSubscription(userID uint) (string, error) {
+	value, err := generateSubToken()
+	if err != nil {
+		return "", err
+	}
+	password, err := generateSubToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UserConfig{}).Where("user_id = ?", userID).Update("password", password)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("用户尚未开通服务")
+		}
+
+		token := models.SubscriptionToken{UserID: userID}
+		return tx.Where("user_id = ?", userID).
+			Assign(models.SubscriptionToken{Token: value}).
+			FirstOrCreate(&token).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.onlineService.KickUser(userID)
+
+	nodes, err := s.nodeService.GetUserNodes(userID)
+	if err != nil {
+		log.Printf("查询用户节点失败，用户ID: %d, 错误: %v", userID, err)
+		return value, nil
+	}
+	nodeIDs := make([]uint, 0, len(nodes))
+	for _, node := range nodes {
+		nodeIDs = append(nodeIDs, node.ID)
+	}
+	s.deployService.RedeployNodes(nodeIDs)
+
+	return value, nil
+}
+
+// 获取用户订阅拉取记录
+func (s *SubscriptionService) GetSubscriptionFetches(userID uint, limit int) ([]models.SubscriptionFetch, error) {
+	var fetches []models.SubscriptionFetch
+	err := s.db.Where("user_id = ?", userID).
+		Order("fetched_at DESC").
+		Limit(clampLimit(limit, 50)).
+		Find(&fetches).Error
+	return fetches, err
+}
+
+// 根据令牌和目标格式生成订阅，target 为空时根据 User-Agent 判断
+func (s *SubscriptionService) GetSubscription(tokenValue, target, userAgent, clientIP string, nodeID uint) (*SubscriptionContent, error) {
+	var token models.SubscriptionToken
+	if err := s.db.Where("token = ?", tokenValue).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, subNotFoundError("无效的订阅链接")
+		}
+		return nil, err
+	}
+
+	var user models.User
+	if err := s.db.First(&user, token.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, subNotFoundError("用户不存在")
+		}
+		return nil, err
+	}
+
+	if err := s.recordFetch(&token, &user, clientIP, userAgent); err != nil {
+		return nil, err
+	}
+
+	entries, userConfig, err := s.userEntries(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if target == "" {
+		target = detectSubTarget(userAgent)
+	}
+
+	// 分享链接和 Hysteria2 官方客户端不支持分流规则，只有 Clash 和 sing-box 应用模板
+	var template *models.RoutingTemplate
+	if target == SubTargetClash || target == SubTargetSingBox {
+		if template, err = s.userRoutingTemplate(user.ID, userConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	var content *SubscriptionContent
+	switch target {
+	case SubTargetLinks:
+		content = renderLinks(entries)
+	case SubTargetClash:
+		content, err = renderClash(entries, template)
+	case SubTargetSingBox:
+		content, err = renderSingBox(entries, template)
+	case SubTargetHysteria2:
+		content, err = renderHysteria2(entries, nodeID)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSubscriptionTarget, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload, download := s.trafficService.GetUserTraffic(&user)
+	content.UserInfo = fmt.Sprintf("upload=%d; download=%d; total=%d",
+		upload, download, user.TrafficLimit)
+	// 没有到期时间的用户不输出 expire，零值时间的 Unix 时间戳为负数
+	if expire := user.ExpireAt.Unix(); expire > 0 {
+		content.UserInfo += fmt.Sprintf("; expire=%d", expire)
+	}
+
+	return content, nil
+}
+
+// 记录订阅拉取，窗口期内不同IP数达到阈值时通知管理员。clientIP 只在请求来自配置的可信代理时
+// 采用 X-Forwarded-For，客户端无法通过伪造该请求头绕过检测
+func (s *SubscriptionService) recordFetch(token *models.SubscriptionToken, user *models.User, clientIP, userAgent string) error {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	now := time.Now()
+	fetch := &models.SubscriptionFetch{
+		UserID:    user.ID,
+		IP:        clientIP,
+		UserAgent: userAgent,
+		FetchedAt: now,
+	}
+	if err := s.db.Create(fetch).Error; err != nil {
+		return err
+	}
+
+	if now.Sub(token.LeakAlertedAt) < subLeakWindow {
+		return nil
+	}
+
+	var distinctIPs int64
+	err := s.db.Model(&models.SubscriptionFetch{}).
+		Where("user_id = ? AND fetched_at > ?", user.ID, now.Add(-subLeakWindow)).
+		Distinct("ip").
+		Count(&distinctIPs).Error
+	if err != nil {
+		return err
+	}
+	if distinctIPs < subLeakThreshold {
+		return nil
+	}
+
+	if err := s.db.Model(token).Update("leak_alerted_at", now).Error; err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.notificationService.SendSubscriptionLeakAlert(user, distinctIPs, subLeakWindow); err != nil {
+			log.Printf("发送订阅泄露告警失败，用户ID: %d, 错误: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// 清理过期的订阅拉取记录
+func (s *SubscriptionService) CleanSubscriptionFetches() error {
+	return s.db.Where("fetched_at < ?", time.Now().Add(-subFetchRetention)).
+		Delete(&models.SubscriptionFetch{}).Error
+}
+
+// 生成用户套餐授权的所有在线节点的客户端配置
+func (s *SubscriptionService) userEntries(userID uint) ([]subscriptionEntry, *models.UserConfig, error) {
+	userConfig, err := s.configManager.GetUserConfig(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, subNotFoundError("用户尚未开通服务")
+		}
+		return nil, nil, err
+	}
+
+	nodes, err := s.nodeService.GetUserNodes(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]subscriptionEntry, 0, len(nodes))
+	for i := range nodes {
+		node := userNode(&nodes[i], userConfig)
+		config, err := s.hy2Service.GenerateClientConfig(node, userConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, subscriptionEntry{
+			node:       node,
+			userConfig: userConfig,
+			config:     config,
+		})
+	}
+	return entries, userConfig, nil
+}
+
+// 根据客户端 User-Agent 判断订阅格式，无法识别时返回分享链接列表
+func detectSubTarget(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "clash"), strings.Contains(ua, "mihomo"), strings.Contains(ua, "stash"):
+		return SubTargetClash
+	case strings.Contains(ua, "sing-box"), strings.Contains(ua, "sfa"), strings.Contains(ua, "sfi"), strings.Contains(ua, "sfm"):
+		return SubTargetSingBox
+	case strings.Contains(ua, "hysteria"):
+		return SubTargetHysteria2
+	default:
+		return SubTargetLinks
+	}
+}
+
+// 客户端内置的出站名称，节点不能与之重名
+var reservedProxyNames = []string{"Proxy", "proxy", "DIRECT", "REJECT", "direct", "block"}
+
+// 生成订阅中不重复的节点名称。节点名称不要求唯一，Clash 和 sing-box 遇到重名的代理会拒绝整个配置，
+// 重名时依次添加 " 2"、" 3" 等后缀；reserved 为已被分组等占用的名称
+func uniqueNames(entries []subscriptionEntry, reserved []string) []string {
+	used := make(map[string]bool, len(entries)+len(reserved))
+	for _, name := range reserved {
+		used[name] = true
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.node.Name
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s %d", entry.node.Name, i)
+		}
+		used[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// 分流模板中分组占用的名称
+func templateGroupNames(template *models.RoutingTemplate) []string {
+	names := append([]string{}, reservedProxyNames...)
+	if template != nil {
+		for _, group := range template.Groups {
+			names = append(names, group.Name)
+		}
+	}
+	return names
+}
+
+// 分享链接列表，每行一个链接，整体 base64 编码（v2rayN、Shadowrocket 等通用格式）
+func renderLinks(entries []subscriptionEntry) *SubscriptionContent {
+	names := uniqueNames(entries, nil)
+	links := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		links = append(links, ShareURI(names[i], entry.config))
+	}
+
+	body := base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+	return &SubscriptionContent{
+		Body:        []byte(body),
+		ContentType: "text/plain; charset=utf-8",
+	}
+}
+
+type clashConfig struct {
+	Proxies     []clashProxy      `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+}
+
+type clashProxy struct {
+	Name           string `yaml:"name"`
+	Type           string `yaml:"type"`
+	Server         string `yaml:"server"`
+	Port           int    `yaml:"port"`
+	Ports          string `yaml:"ports,omitempty"`
+	HopInterval    int    `yaml:"hop-interval,omitempty"`
+	Password       string `yaml:"password"`
+	Up             string `yaml:"up,omitempty"`
+	Down           string `yaml:"down,omitempty"`
+	Obfs           string `yaml:"obfs,omitempty"`
+	ObfsPassword   string `yaml:"obfs-password,omitempty"`
+	SNI            string `yaml:"sni,omitempty"`
+	SkipCertVerify bool   `yaml:"skip-cert-verify"`
+}
+
+type clashProxyGroup struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Proxies  []string `yaml:"proxies"`
+	URL      string   `yaml:"url,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+// Clash Meta / mihomo 配置，template 为空时所有流量走代理
+func renderClash(entries []subscriptionEntry, template *models.RoutingTemplate) (*SubscriptionContent, error) {
+	config := clashConfig{
+		Proxies: make([]clashProxy, 0, len(entries)),
+	}
+
+	names := uniqueNames(entries, templateGroupNames(template))
+	for i, entry := range entries {
+		up, down := ClientBandwidthMbps(entry.node, entry.userConfig)
+		proxy := clashProxy{
+			Name:        names[i],
+			Type:        "hysteria2",
+			Server:      entry.node.Host,
+			Port:        entry.node.Port,
+			Ports:       entry.node.HopPorts,
+			HopInterval: entry.node.HopInterval,
+			Password:    entry.config.Auth,
+			Up:          formatMbps(up),
+			Down:        formatMbps(down),
+			SNI:         entry.config.TLS.SNI,
+		}
+		if entry.config.Obfs != nil {
+			proxy.Obfs = entry.config.Obfs.Type
+			proxy.ObfsPassword = entry.config.Obfs.Salamander.Password
+		}
+		config.Proxies = append(config.Proxies, proxy)
+	}
+	config.ProxyGroups, config.Rules = clashRouting(template, names)
+
+	body, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return &SubscriptionContent{
+		Body:        body,
+		ContentType: "application/yaml; charset=utf-8",
+	}, nil
+}
+
+// sing-box 配置，template 为空时所有流量走代理
+func renderSingBox(entries []subscriptionEntry, template *models.RoutingTemplate) (*SubscriptionContent, error) {
+	outbounds := make([]map[string]interface{}, 0, len(entries)+3)
+	tags := uniqueNames(entries, templateGroupNames(template))
+	for i, entry := range entries {
+		outbound := map[string]interface{}{
+			"type":        "hysteria2",
+			"tag":         tags[i],
+			"server":      entry.node.Host,
+			"server_port": entry.node.Port,
+			"password":    entry.config.Auth,
+			"tls": map[string]interface{}{
+				"enabled":     true,
+				"server_name": entry.config.TLS.SNI,
+			},
+		}
+		if entry.node.HopPorts != "" {
+			// sing-box 的端口范围使用冒号分隔
+			outbound["server_ports"] = strings.Split(strings.ReplaceAll(entry.node.HopPorts, "-", ":"), ",")
+			if entry.node.HopInterval > 0 {
+				outbound["hop_interval"] = strconv.Itoa(entry.node.HopInterval) + "s"
+			}
+		}
+		if up, down := ClientBandwidthMbps(entry.node, entry.userConfig); up > 0 || down > 0 {
+			outbound["up_mbps"] = up
+			outbound["down_mbps"] = down
+		}
+		if entry.config.Obfs != nil {
+			outbound["obfs"] = map[string]string{
+				"type":     entry.config.Obfs.Type,
+				"password": entry.config.Obfs.Salamander.Password,
+			}
+		}
+		outbounds = append(outbounds, outbound)
+	}
+	groups, route := singBoxRouting(template, tags)
+	outbounds = append(outbounds, groups...)
+	outbounds = append(outbounds,
+		map[string]interface{}{"type": "direct", "tag": "direct"},
+		map[string]interface{}{"type": "block", "tag": "block"},
+	)
+
+	config := map[string]interface{}{
+		"inbounds": []map[string]interface{}{
+			{"type": "mixed", "tag": "mixed-in", "listen": "127.0.0.1", "listen_port": 2080},
+		},
+		"outbounds": outbounds,
+		"route":     route,
+	}
+
+	body, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &SubscriptionContent{
+		Body:        body,
+		ContentType: "application/json; charset=utf-8",
+	}, nil
+}
+
+// Hysteria2 官方客户端配置只支持单个服务器，未指定节点时使用第一个节点
+func renderHysteria2(entries []subscriptionEntry, nodeID uint) (*SubscriptionContent, error) {
+	if len(entries) == 0 {
+		return nil, subNotFoundError("没有可用节点")
+	}
+
+	entry := entries[0]
+	if nodeID > 0 {
+		found := false
+		for _, e := range entries {
+			if e.node.ID == nodeID {
+				entry, found = e, true
+				break
+			}
+		}
+		if !found {
+			return nil, subNotFoundError("节点不可用或当前套餐无权使用该节点")
+		}
+	}
+
+	body, err := EncodeConfig(entry.config, "yaml")
+	if err != nil {
+		return nil, err
+	}
+	return &SubscriptionContent{
+		Body:        body,
+		ContentType: "application/yaml; charset=utf-8",
+	}, nil
+}
+
+func generateSubToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"hysteria2-panel/models"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// 构造订阅条目，客户端配置只包含渲染用到的字段
+func newSubscriptionEntry(id uint, name string, node models.Node) subscriptionEntry {
+	node.ID = id
+	node.Name = name
+	if node.Host == "" {
+		node.Host = "node.example.com"
+	}
+	if node.Port == 0 {
+		node.Port = 443
+	}
+	return subscriptionEntry{
+		node:       &node,
+		userConfig: &models.UserConfig{UserID: 7, Password: "secret", UpSpeed: 50, DownSpeed: 200},
+		config: &ClientConfig{
+			Server: node.Host,
+			Auth:   "7:secret",
+			TLS:    &ClientTLS{SNI: node.Host},
+		},
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	entries := []subscriptionEntry{
+		newSubscriptionEntry(1, "香港", models.Node{}),
+		newSubscriptionEntry(2, "香港", models.Node{}),
+		newSubscriptionEntry(3, "香港 2", models.Node{}),
+		newSubscriptionEntry(4, "Proxy", models.Node{}),
+		newSubscriptionEntry(5, "香港", models.Node{}),
+	}
+
+	got := uniqueNames(entries, []string{"Proxy"})
+	want := []string{"香港", "香港 2", "香港 2 2", "Proxy 2", "香港 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("uniqueNames() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderClash(t *testing.T) {
+	entries := []subscriptionEntry{
+		newSubscriptionEntry(1, "香港", models.Node{HopPorts: "20000-30000", HopInterval: 30}),
+		newSubscriptionEntry(2, "香港", models.Node{Host: "jp.example.com", Port: 8443, BandwidthUp: "100 mbps"}),
+	}
+	entries[1].config.Obfs = &ObfsConfig{Type: "salamander", Salamander: &SalamanderConfig{Password: "obfs"}}
+
+	content, err := renderClash(entries, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config clashConfig
+	if err := yaml.Unmarshal(content.Body, &config); err != nil {
+		t.Fatalf("生成的 Clash 配置无法解析: %v", err)
+	}
+
+	want := []clashProxy{
+		{Name: "香港", Type: "hysteria2", Server: "node.example.com", Port: 443, Ports: "20000-30000", HopInterval: 30,
+			Password: "7:secret", Up: "50 mbps", Down: "200 mbps", SNI: "node.example.com"},
+		{Name: "香港 2", Type: "hysteria2", Server: "jp.example.com", Port: 8443,
+			Password: "7:secret", Up: "50 mbps", Down: "100 mbps", Obfs: "salamander", ObfsPassword: "obfs", SNI: "jp.example.com"},
+	}
+	if !reflect.DeepEqual(config.Proxies, want) {
+		t.Errorf("proxies = %+v, want %+v", config.Proxies, want)
+	}
+
+	// 未配置分流模板时所有节点放入同一个代理分组，其余流量走代理
+	if len(config.ProxyGroups) != 1 || !reflect.DeepEqual(config.ProxyGroups[0].Proxies, []string{"香港", "香港 2"}) {
+		t.Errorf("proxy-groups = %+v", config.ProxyGroups)
+	}
+	if len(config.Rules) == 0 || config.Rules[len(config.Rules)-1] != "MATCH,"+config.ProxyGroups[0].Name {
+		t.Errorf("rules = %v", config.Rules)
+	}
+}
+
+func TestRenderSingBox(t *testing.T) {
+	entries := []subscriptionEntry{
+		newSubscriptionEntry(1, "香港", models.Node{HopPorts: "20000-30000,40000", HopInterval: 30}),
+		newSubscriptionEntry(2, "日本", models.Node{IgnoreClientBandwidth: true}),
+	}
+
+	content, err := renderSingBox(entries, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Outbounds []map[string]interface{} `json:"outbounds"`
+		Route     map[string]interface{}   `json:"route"`
+	}
+	if err := json.Unmarshal(content.Body, &config); err != nil {
+		t.Fatalf("生成的 sing-box 配置无法解析: %v", err)
+	}
+
+	tags := make(map[string]map[string]interface{})
+	for _, outbound := range config.Outbounds {
+		tags[outbound["tag"].(string)] = outbound
+	}
+	for _, tag := range []string{"香港", "日本", "direct", "block"} {
+		if tags[tag] == nil {
+			t.Fatalf("缺少出站 %q: %v", tag, config.Outbounds)
+		}
+	}
+
+	hk := tags["香港"]
+	if !reflect.DeepEqual(hk["server_ports"], []interface{}{"20000:30000", "40000"}) || hk["hop_interval"] != "30s" {
+		t.Errorf("端口跳跃应转换为 sing-box 格式: %v", hk)
+	}
+	if hk["up_mbps"] != float64(50) || hk["down_mbps"] != float64(200) {
+		t.Errorf("带宽应使用用户限速: %v", hk)
+	}
+	if _, ok := tags["日本"]["up_mbps"]; ok {
+		t.Errorf("忽略客户端带宽的节点不应声明带宽: %v", tags["日本"])
+	}
+	if config.Route["final"] == nil {
+		t.Errorf("未配置分流模板时应设置默认出站: %v", config.Route)
+	}
+}
+
+func TestRecordFetchLeakDetection(t *testing.T) {
+	tests := []struct {
+		name        string
+		alertedAgo  time.Duration // 距上次告警的时间，0表示从未告警
+		distinctIPs int64
+		wantCount   bool // 是否统计窗口期内的不同IP数
+		wantAlert   bool // 是否记录告警时间
+	}{
+		{"未达到阈值", 0, subLeakThreshold - 1, true, false},
+		{"达到阈值", 0, subLeakThreshold, true, true},
+		{"上次告警已超过窗口期", subLeakWindow + time.Hour, subLeakThreshold + 5, true, true},
+		{"窗口期内已告警", time.Hour, subLeakThreshold + 5, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, statements := newDryRunDB(t)
+			db.Callback().Create().After("gorm:create").Register("test:record", func(tx *gorm.DB) {
+				*statements = append(*statements, tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...))
+			})
+			// 统计不同IP数时返回预设的数量
+			db.Callback().Query().After("gorm:query").Register("test:count", func(tx *gorm.DB) {
+				if count, ok := tx.Statement.Dest.(*int64); ok {
+					*count = tt.distinctIPs
+					tx.RowsAffected = 1
+				}
+			})
+
+			// 告警通过独立的实例发送，未配置告警方式时不会实际发送
+			notifyDB, _ := newDryRunDB(t)
+			s := &SubscriptionService{
+				db:                  db,
+				notificationService: NewNotificationService(notifyDB, nil, NewSettingService(notifyDB)),
+			}
+
+			token := &models.SubscriptionToken{ID: 1, UserID: 7}
+			if tt.alertedAgo > 0 {
+				token.LeakAlertedAt = time.Now().Add(-tt.alertedAgo)
+			}
+			if err := s.recordFetch(token, &models.User{ID: 7}, "203.0.113.5", "clash"); err != nil {
+				t.Fatal(err)
+			}
+
+			var created, counted, alerted bool
+			for _, sql := range *statements {
+				switch {
+				case strings.HasPrefix(sql, "INSERT INTO `subscription_fetches`"):
+					created = strings.Contains(sql, "'203.0.113.5'")
+				case strings.Contains(sql, "COUNT(DISTINCT(`ip`))"):
+					counted = strings.Contains(sql, "user_id = 7")
+				case strings.Contains(sql, "`leak_alerted_at`="):
+					alerted = true
+				}
+			}
+			if !created {
+				t.Errorf("应记录本次拉取: %v", *statements)
+			}
+			if counted != tt.wantCount {
+				t.Errorf("统计不同IP数 = %v, want %v: %v", counted, tt.wantCount, *statements)
+			}
+			if alerted != tt.wantAlert {
+				t.Errorf("记录告警时间 = %v, want %v: %v", alerted, tt.wantAlert, *statements)
+			}
+		})
+	}
+}
+
+// 分配了独立端口的用户连接自己的实例，不使用端口跳跃
+func TestRenderUserPort(t *testing.T) {
+	userConfig := &models.UserConfig{UserID: 7, Password: "secret", Port: intPtr(30001), UpSpeed: 50, DownSpeed: 200}
+	node := userNode(&models.Node{Host: "node.example.com", Port: 443, HopPorts: "20000-30000", HopInterval: 30}, userConfig)
+	entries := []subscriptionEntry{newSubscriptionEntry(1, "香港", *node)}
+
+	clash, err := renderClash(entries, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clashConf clashConfig
+	if err := yaml.Unmarshal(clash.Body, &clashConf); err != nil {
+		t.Fatalf("生成的 Clash 配置无法解析: %v", err)
+	}
+	if len(clashConf.Proxies) != 1 || clashConf.Proxies[0].Port != 30001 || clashConf.Proxies[0].Ports != "" || clashConf.Proxies[0].HopInterval != 0 {
+		t.Errorf("Clash proxies = %+v, want 端口 30001 且不跳跃", clashConf.Proxies)
+	}
+
+	singBox, err := renderSingBox(entries, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var singBoxConf struct {
+		Outbounds []map[string]interface{} `json:"outbounds"`
+	}
+	if err := json.Unmarshal(singBox.Body, &singBoxConf); err != nil {
+		t.Fatalf("生成的 sing-box 配置无法解析: %v", err)
+	}
+	var hk map[string]interface{}
+	for _, outbound := range singBoxConf.Outbounds {
+		if outbound["tag"] == "香港" {
+			hk = outbound
+		}
+	}
+	if hk == nil || hk["server_port"] != float64(30001) || hk["server_ports"] != nil {
+		t.Errorf("sing-box outbound = %v, want 端口 30001 且不跳跃", hk)
+	}
+}
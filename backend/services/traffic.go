@@ -15,6 +15,8 @@ type TrafficService struct {
 	mutex sync.RWMutex
 	// 用户实时流量统计，key 为用户ID
 	stats map[uint]*TrafficStat
+	// 节点实时流量统计，key 为节点ID
+	nodeStats map[uint]*TrafficStat
 }
 
 type TrafficStat struct {
@@ -25,18 +27,31 @@ type TrafficStat struct {
 
 func NewTrafficService(db *gorm.DB) *TrafficService {
 	service := &TrafficService{
-		db:    db,
-		stats: make(map[uint]*TrafficStat),
+		db:        db,
+		stats:     make(map[uint]*TrafficStat),
+		nodeStats: make(map[uint]*TrafficStat),
 	}
 	go service.syncTrafficPeriodically()
 	return service
 }
 
-// 记录流量使用
-func (s *TrafficService) RecordTraffic(userID uint, upload, download int64) error {
+// 记录节点上报的用户流量
+func (s *TrafficService) RecordTraffic(nodeID, userID uint, upload, download int64) error {
+	if upload < 0 || download < 0 {
+		return errors.New("流量不能为负数")
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	nodeStat, exists := s.nodeStats[nodeID]
+	if !exists {
+		nodeStat = &TrafficStat{LastSync: time.Now()}
+		s.nodeStats[nodeID] = nodeStat
+	}
+	nodeStat.Upload += upload
+	nodeStat.Download += download
+
 	stat, exists := s.stats[userID]
 	if !exists {
 		stat = &TrafficStat{LastSync: time.Now()}
@@ -89,6 +104,25 @@ func (s *TrafficService) CheckTrafficLimit(userID string) (bool, error) {
 	return true, nil
 }
 
+// 获取用户已使用的上传和下载流量（含尚未同步到数据库的部分）
+func (s *TrafficService) GetUserTraffic(user *models.User) (int64, int64) {
+	// 管理员重置已用流量后上传部分可能大于总量
+	upload := user.Upload
+	if upload > user.Traffic {
+		upload = user.Traffic
+	}
+	download := user.Traffic - upload
+
+	s.mutex.RLock()
+	if stat, exists := s.stats[user.ID]; exists {
+		upload += stat.Upload
+		download += stat.Download
+	}
+	s.mutex.RUnlock()
+
+	return upload, download
+}
+
 // 定期同步流量数据到数据库
 func (s *TrafficService) syncTrafficPeriodically() {
 	ticker := time.NewTicker(5 * time.Minute)
@@ -97,6 +131,9 @@ func (s *TrafficService) syncTrafficPeriodically() {
 		for userID := range s.stats {
 			_ = s.syncUserTraffic(userID)
 		}
+		for nodeID := range s.nodeStats {
+			_ = s.syncNodeTraffic(nodeID)
+		}
 		s.mutex.Unlock()
 	}
 }
@@ -110,8 +147,33 @@ func (s *TrafficService) syncUserTraffic(userID uint) error {
 
 	err := s.db.Model(&models.User{}).
 		Where("id = ?", userID).
-		UpdateColumn("traffic", gorm.Expr("traffic + ?", stat.Upload+stat.Download)).
-		Error
+		UpdateColumns(map[string]interface{}{
+			"traffic": gorm.Expr("traffic + ?", stat.Upload+stat.Download),
+			"upload":  gorm.Expr("upload + ?", stat.Upload),
+		}).Error
+
+	if err == nil {
+		stat.Upload = 0
+		stat.Download = 0
+		stat.LastSync = time.Now()
+	}
+
+	return err
+}
+
+// 同步单个节点的流量数据到数据库
+func (s *TrafficService) syncNodeTraffic(nodeID uint) error {
+	stat := s.nodeStats[nodeID]
+	if stat.Upload == 0 && stat.Download == 0 {
+		return nil
+	}
+
+	err := s.db.Model(&models.Node{}).
+		Where("id = ?", nodeID).
+		UpdateColumns(map[string]interface{}{
+			"total_upload": gorm.Expr("total_upload + ?", stat.Upload),
+			"total_down":   gorm.Expr("total_down + ?", stat.Download),
+		}).Error
 
 	if err == nil {
 		stat.Upload = 0
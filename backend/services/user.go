@@ -10,11 +10,15 @@ import (
 )
 
 type UserService struct {
-	db *gorm.DB
+	db            *gorm.DB
+	configManager *ConfigManagerService
 }
 
-func NewUserService(db *gorm.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *gorm.DB, configManager *ConfigManagerService) *UserService {
+	return &UserService{
+		db:            db,
+		configManager: configManager,
+	}
 }
 
 func (s *UserService) Register(username, password, email string) error {
@@ -40,7 +44,13 @@ func (s *UserService) Register(username, password, email string) error {
 		Email:    email,
 	}
 
-	return s.db.Create(user).Error
+	// 创建用户并开通认证配置
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return s.configManager.ProvisionUserConfig(tx, user.ID)
+	})
 }
 
 func (s *UserService) Login(username, password string) (string, error) {
@@ -58,7 +68,7 @@ func (s *UserService) Login(username, password string) (string, error) {
 	}
 
 	// 生成 JWT token
-	token, err := utils.GenerateToken(user.ID)
+	token, err := utils.GenerateToken(user.ID, user.IsAdmin)
 	if err != nil {
 		return "", err
 	}
@@ -84,10 +84,13 @@ func (s *UserManagerService) DeleteUser(id string) error {
 
 	// 开始事务
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		// 删除用户配置
+		// 删除用户配置，释放分配的端口
 		if err := tx.Where("user_id = ?", userID).Delete(&models.UserConfig{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.SubscriptionToken{}).Error; err != nil {
+			return err
+		}
 
 		// 删除用户
 		result := tx.Delete(&models.User{}, userID)
@@ -46,6 +46,7 @@ install_panel() {
     "tls_key_path": "/etc/hysteria2-panel/cert/key.pem",
     "domain": "",
     "email": "",
+    "trusted_proxies": ["127.0.0.1", "::1"],
     "database": {
         "type": "mysql",
         "host": "localhost",
@@ -63,6 +64,11 @@ EOF
     go mod tidy
     go build -o hysteria2-panel
     
+    # 编译节点代理，供节点安装脚本下载
+    mkdir -p agent
+    CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o agent/hy2-agent-linux-amd64 ./cmd/agent
+    CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o agent/hy2-agent-linux-arm64 ./cmd/agent
+    
     # 7. 配置系统服务
     cat > /etc/systemd/system/hysteria2-panel.service << EOF
 [Unit]